@@ -0,0 +1,72 @@
+package main
+
+import (
+  "os"
+  "os/exec"
+  "sync"
+  "errors"
+  "context"
+  "syscall"
+)
+
+var errSuperseded = errors.New("Build was superseded by a newer one")
+
+/**
+ * Runs the build step. Starting a build cancels any build which is already
+ * running, since its result would be stale by the time it finished.
+ */
+type builder struct {
+  sync.Mutex
+  command string
+  cancel  context.CancelFunc
+  done    chan struct{}
+}
+
+/**
+ * Create a builder
+ */
+func newBuilder(c string) *builder {
+  return &builder{sync.Mutex{}, c, nil, nil}
+}
+
+/**
+ * Build. If another build is started before this one completes, this one is
+ * killed and errSuperseded is returned.
+ */
+func (b *builder) Build() error {
+  b.Lock()
+  if b.cancel != nil {
+    b.cancel()
+  }
+  cxt, cancel := context.WithCancel(context.Background())
+  prev, done := b.done, make(chan struct{})
+  b.cancel, b.done = cancel, done
+  b.Unlock()
+  
+  defer close(done)
+  defer cancel()
+  
+  if prev != nil {
+    <- prev // wait for the previous build to be killed
+  }
+  if cxt.Err() != nil {
+    return errSuperseded
+  }
+  
+  logf("Building: %v", b.command)
+  
+  cmd := exec.CommandContext(cxt, "/bin/sh", "-c", b.command)
+  cmd.Stdout = os.Stdout
+  cmd.Stderr = os.Stderr
+  cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+  cmd.Cancel = func() error {
+    return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) // the whole group, compilers and all
+  }
+  
+  err := cmd.Run()
+  if cxt.Err() != nil {
+    logf("Build cancelled, files changed while it was running")
+    return errSuperseded
+  }
+  return err
+}
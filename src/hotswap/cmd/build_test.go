@@ -0,0 +1,44 @@
+package main
+
+import (
+  "os"
+  "time"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestBuildSuperseded(t *testing.T) {
+  dir := t.TempDir()
+  started := filepath.Join(dir, "started")
+  orphan := filepath.Join(dir, "orphan")
+  
+  // the first build leaves something running in the background, which must
+  // be killed along with it; later builds succeed straight away
+  b := newBuilder("[ -e "+ started +" ] && exit 0; touch "+ started +"; (sleep 1; touch "+ orphan +") & wait")
+  
+  first := make(chan error, 1)
+  go func() {
+    first <- b.Build()
+  }()
+  for deadline := time.Now().Add(time.Second * 5); ; <- time.After(time.Millisecond * 10) {
+    if _, err := os.Stat(started); err == nil {
+      break
+    }
+    if time.Now().After(deadline) {
+      t.Fatal("First build did not start")
+    }
+  }
+  
+  assert.Nil(t, b.Build())
+  select {
+    case err := <- first:
+      assert.Equal(t, errSuperseded, err)
+    case <- time.After(time.Second):
+      t.Fatal("First build was not cancelled")
+  }
+  
+  <- time.After(time.Millisecond * 1500)
+  _, err := os.Stat(orphan)
+  assert.True(t, os.IsNotExist(err), "a process started by the first build survived")
+}
@@ -0,0 +1,179 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+  "os"
+  "os/exec"
+  "fmt"
+  "time"
+  "bufio"
+  "strings"
+  "strconv"
+  "syscall"
+  "path/filepath"
+)
+
+/**
+ * A cgroup v2 group which contains one generation of the managed process and
+ * everything it starts, however hard it tries to get away.
+ */
+type cgroup struct {
+  path  string
+  dir   *os.File
+}
+
+/**
+ * Find the cgroup we're running in, which is where groups for our generations
+ * are created unless we're told otherwise.
+ */
+func cgroupParent() (string, error) {
+  root, err := cgroupMount()
+  if err != nil {
+    return "", err
+  }
+  
+  f, err := os.Open("/proc/self/cgroup")
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+  
+  r := bufio.NewScanner(f)
+  for r.Scan() {
+    if l := r.Text(); strings.HasPrefix(l, "0::") {
+      return filepath.Join(root, l[3:]), nil
+    }
+  }
+  if err = r.Err(); err != nil {
+    return "", err
+  }
+  return "", fmt.Errorf("Not running in a cgroup v2 hierarchy")
+}
+
+/**
+ * Find where the cgroup v2 hierarchy is mounted
+ */
+func cgroupMount() (string, error) {
+  f, err := os.Open("/proc/self/mountinfo")
+  if err != nil {
+    return "", err
+  }
+  defer f.Close()
+  
+  r := bufio.NewScanner(f)
+  for r.Scan() {
+    l := r.Text()
+    x := strings.Index(l, " - ")
+    if x < 0 {
+      continue
+    }
+    if t := strings.Fields(l[x+3:]); len(t) > 0 && t[0] == "cgroup2" {
+      if m := strings.Fields(l[:x]); len(m) > 4 {
+        return m[4], nil
+      }
+    }
+  }
+  if err = r.Err(); err != nil {
+    return "", err
+  }
+  return "", fmt.Errorf("No cgroup v2 hierarchy is mounted")
+}
+
+/**
+ * Create a group
+ */
+func newCgroup(parent, name string) (*cgroup, error) {
+  p := filepath.Join(parent, name)
+  err := os.Mkdir(p, 0755)
+  if err != nil {
+    return nil, err
+  }
+  d, err := os.Open(p)
+  if err != nil {
+    os.Remove(p)
+    return nil, err
+  }
+  return &cgroup{p, d}, nil
+}
+
+/**
+ * Arrange for a command to be started in this group. It's placed there as it's
+ * created, so nothing it does can happen outside the group.
+ */
+func (c *cgroup) Attach(cmd *exec.Cmd) {
+  if cmd.SysProcAttr == nil {
+    cmd.SysProcAttr = &syscall.SysProcAttr{}
+  }
+  cmd.SysProcAttr.UseCgroupFD = true
+  cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+/**
+ * Kill everything in the group, wait for it to be empty and remove it. This
+ * may be called again if it fails; once the group is gone it does nothing.
+ */
+func (c *cgroup) Destroy(timeout time.Duration) error {
+  deadline := time.Now().Add(timeout)
+  for {
+    pids, err := c.procs()
+    if os.IsNotExist(err) {
+      c.close() // someone else removed it
+      return nil
+    }
+    if err != nil {
+      return err
+    }
+    if len(pids) < 1 {
+      break
+    }
+    if time.Now().After(deadline) {
+      return fmt.Errorf("%d processes are still running in %v", len(pids), c.path)
+    }
+    
+    // cgroup.kill does this atomically where it's supported
+    err = os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0644)
+    if err != nil {
+      for _, e := range pids {
+        syscall.Kill(e, syscall.SIGKILL)
+      }
+    }
+    <- time.After(time.Millisecond * 50)
+  }
+  
+  c.close()
+  err := os.Remove(c.path)
+  if os.IsNotExist(err) {
+    return nil
+  }
+  return err
+}
+
+/**
+ * Release our handle on the group directory
+ */
+func (c *cgroup) close() {
+  if c.dir != nil {
+    c.dir.Close()
+    c.dir = nil
+  }
+}
+
+/**
+ * List the processes in the group
+ */
+func (c *cgroup) procs() ([]int, error) {
+  data, err := os.ReadFile(filepath.Join(c.path, "cgroup.procs"))
+  if err != nil {
+    return nil, err
+  }
+  var pids []int
+  for _, e := range strings.Fields(string(data)) {
+    pid, err := strconv.Atoi(e)
+    if err == nil {
+      pids = append(pids, pid)
+    }
+  }
+  return pids, nil
+}
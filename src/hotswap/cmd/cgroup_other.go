@@ -0,0 +1,43 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+  "os/exec"
+  "time"
+  "errors"
+)
+
+var errNoCgroups = errors.New("Cgroups are only supported on Linux")
+
+/**
+ * A cgroup; unsupported on this platform
+ */
+type cgroup struct {}
+
+/**
+ * Find the cgroup we're running in
+ */
+func cgroupParent() (string, error) {
+  return "", errNoCgroups
+}
+
+/**
+ * Create a group
+ */
+func newCgroup(parent, name string) (*cgroup, error) {
+  return nil, errNoCgroups
+}
+
+/**
+ * Arrange for a command to be started in this group
+ */
+func (c *cgroup) Attach(cmd *exec.Cmd) {}
+
+/**
+ * Kill everything in the group and remove it
+ */
+func (c *cgroup) Destroy(timeout time.Duration) error {
+  return errNoCgroups
+}
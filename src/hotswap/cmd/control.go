@@ -0,0 +1,298 @@
+package main
+
+import (
+  "io"
+  "fmt"
+  "net"
+  "bufio"
+  "errors"
+  "strings"
+  "net/http"
+)
+
+import (
+  "hotswap"
+)
+
+/**
+ * Output kept for the logs command
+ */
+var tap = newOutputTap(64 << 10)
+
+/**
+ * Serve the control protocol on a unix socket. The protocol is line-oriented:
+ * a client sends one command and receives a reply prefixed with "ok" or
+ * "error", followed by any further output, after which the connection is
+ * closed. Commands are:
+ *
+ *   status           report on the managed process
+ *   restart          restart the managed process
+ *   stop             stop the managed process until it's started again
+ *   start            start the managed process after it was stopped
+ *   pause            stop reacting to file changes
+ *   resume           react to file changes again
+ *   logs [--follow]  write recent output and optionally follow it
+ *   quit             stop the managed process and exit
+ *
+ */
+func serveControl(p string) error {
+  err := hotswap.RemoveStaleSocket(p)
+  if err != nil {
+    return err
+  }
+  
+  l, err := net.Listen("unix", p)
+  if err != nil {
+    return err
+  }
+  
+  go func() {
+    for {
+      conn, err := l.Accept()
+      if err != nil {
+        return
+      }
+      go func() {
+        defer conn.Close()
+        r := bufio.NewReader(conn)
+        line, err := r.ReadString('\n')
+        if err != nil && line == "" {
+          return
+        }
+        
+        // watch for the client going away while we're following
+        done := make(chan struct{})
+        go func() {
+          io.Copy(io.Discard, r)
+          close(done)
+        }()
+        
+        f := strings.Fields(line)
+        if len(f) < 1 {
+          fmt.Fprintf(conn, "error No command\n")
+          return
+        }
+        control(conn, f[0], f[1:], done)
+      }()
+    }
+  }()
+  
+  return nil
+}
+
+/**
+ * Serve the control protocol over HTTP. Commands are mapped to paths, so
+ * 'POST /restart' restarts the process and 'GET /logs?follow=true' follows
+ * its output. Only loopback addresses are accepted.
+ *
+ * Since any web page can make requests to localhost, requests from browsers
+ * (those with an Origin header) are refused, as are requests addressed to any
+ * other host, which guards against DNS rebinding. Commands which change
+ * anything must also be sent with an 'X-Hotswap' header, which a page can't
+ * add without permission:
+ *
+ *   curl -X POST -H 'X-Hotswap: 1' localhost:7979/restart
+ *
+ */
+func serveControlHTTP(addr string) error {
+  err := checkLoopback(addr)
+  if err != nil {
+    return err
+  }
+  
+  l, err := net.Listen("tcp", addr)
+  if err != nil {
+    return err
+  }
+  if a, ok := l.Addr().(*net.TCPAddr); !ok || !a.IP.IsLoopback() {
+    l.Close() // localhost resolved to something it shouldn't have
+    return fmt.Errorf("Control address is not a loopback address: %v", l.Addr())
+  }
+  
+  go http.Serve(l, http.HandlerFunc(controlHTTP))
+  return nil
+}
+
+/**
+ * Handle a control request over HTTP
+ */
+func controlHTTP(rsp http.ResponseWriter, req *http.Request) {
+  host := req.Host
+  if h, _, err := net.SplitHostPort(host); err == nil {
+    host = h
+  }else{
+    host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+  }
+  if checkLoopback(net.JoinHostPort(host, "0")) != nil {
+    http.Error(rsp, "Requests must be addressed to localhost", http.StatusForbidden)
+    return
+  }
+  if req.Header.Get("Origin") != "" {
+    http.Error(rsp, "Requests from browsers are not allowed", http.StatusForbidden)
+    return
+  }
+  
+  c := strings.Trim(req.URL.Path, "/")
+  if req.Method != "POST" && c != "status" && c != "logs" {
+    rsp.WriteHeader(http.StatusMethodNotAllowed)
+    return
+  }
+  if req.Method == "POST" && req.Header.Get("X-Hotswap") == "" {
+    http.Error(rsp, "Commands require an X-Hotswap header", http.StatusForbidden)
+    return
+  }
+  
+  var a []string
+  if v := req.URL.Query().Get("follow"); v != "" && v != "false" {
+    a = append(a, "--follow")
+  }
+  
+  rsp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  control(flushWriter{rsp}, c, a, req.Context().Done())
+}
+
+/**
+ * Writes through an HTTP response, flushing as it goes
+ */
+type flushWriter struct {
+  http.ResponseWriter
+}
+
+/**
+ * Write and flush
+ */
+func (w flushWriter) Write(p []byte) (int, error) {
+  n, err := w.ResponseWriter.Write(p)
+  if f, ok := w.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+  return n, err
+}
+
+/**
+ * Carry out a control command, writing the reply
+ */
+func control(w io.Writer, c string, a []string, done <-chan struct{}) {
+  var err error
+  switch c {
+    case "status":
+      fmt.Fprintf(w, "ok\n")
+      for _, e := range statusLines() {
+        fmt.Fprintln(w, e)
+      }
+      return
+    case "restart":
+      logf("Restarting (control)...")
+      term(process())
+    case "stop":
+      err = stop()
+    case "start":
+      err = start()
+    case "pause":
+      err = setPaused(true)
+    case "resume":
+      err = setPaused(false)
+    case "logs":
+      follow := len(a) > 0 && (a[0] == "--follow" || a[0] == "-f")
+      followLogs(w, follow, done)
+      return
+    case "quit":
+      fmt.Fprintf(w, "ok\n")
+      logf("Quitting (control)...")
+      go shutdown()
+      return
+    default:
+      err = fmt.Errorf("Unknown command: %v", c)
+  }
+  if err != nil {
+    fmt.Fprintf(w, "error %v\n", err)
+  }else{
+    fmt.Fprintf(w, "ok\n")
+  }
+}
+
+/**
+ * Write recent output and, optionally, follow it until we're done
+ */
+func followLogs(w io.Writer, follow bool, done <-chan struct{}) {
+  fmt.Fprintf(w, "ok\n")
+  if !follow {
+    w.Write(tap.Recent())
+    return
+  }
+  
+  recent, out, cancel := tap.Follow()
+  defer cancel()
+  
+  _, err := w.Write(recent)
+  for err == nil {
+    select {
+      case p := <- out:
+        _, err = w.Write(p)
+      case <- done:
+        return
+    }
+  }
+}
+
+/**
+ * Make sure an address is only reachable from this machine. The control
+ * endpoint is unauthenticated, so it's never exposed to the network.
+ */
+func checkLoopback(addr string) error {
+  host, _, err := net.SplitHostPort(addr)
+  if err != nil {
+    return err
+  }
+  if host == "localhost" {
+    return nil
+  }
+  if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+    return nil
+  }
+  return fmt.Errorf("Control address must be on localhost or a loopback address: %v", addr)
+}
+
+/**
+ * Stop the managed process until it's started again
+ */
+func stop() error {
+  status.Lock()
+  if status.resume != nil {
+    status.Unlock()
+    return errors.New("The process is already stopped")
+  }
+  status.resume = make(chan struct{})
+  status.Unlock()
+  logf("Stopping process...")
+  term(process())
+  return nil
+}
+
+/**
+ * Start the managed process after it was stopped
+ */
+func start() error {
+  status.Lock()
+  defer status.Unlock()
+  if status.resume == nil {
+    return errors.New("The process is not stopped")
+  }
+  logf("Starting process...")
+  close(status.resume)
+  status.resume = nil
+  return nil
+}
+
+/**
+ * Wait until the managed process is allowed to run
+ */
+func waitStarted() {
+  status.Lock()
+  r := status.resume
+  status.Unlock()
+  if r != nil {
+    <- r
+  }
+}
+
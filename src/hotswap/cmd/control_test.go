@@ -0,0 +1,47 @@
+package main
+
+import (
+  "fmt"
+  "testing"
+  "net/http"
+  "net/http/httptest"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestCheckLoopback(t *testing.T) {
+  for _, e := range []string{"localhost:7979", "127.0.0.1:7979", "127.1.2.3:80", "[::1]:7979"} {
+    assert.Nil(t, checkLoopback(e), e)
+  }
+  for _, e := range []string{":7979", "0.0.0.0:7979", "[::]:7979", "192.168.1.10:7979", "example.com:7979", "localhost"} {
+    assert.NotNil(t, checkLoopback(e), e)
+  }
+}
+
+func TestControlHTTP(t *testing.T) {
+  tests := []struct{
+    method  string
+    target  string
+    header  map[string]string
+    status  int
+  }{
+    {"GET",  "http://localhost:7979/status", nil, http.StatusOK},
+    {"GET",  "http://127.0.0.1:7979/status", nil, http.StatusOK},
+    {"GET",  "http://[::1]:7979/status", nil, http.StatusOK},
+    {"GET",  "http://[::1]/status", nil, http.StatusOK},
+    {"GET",  "http://attacker.example:7979/logs", nil, http.StatusForbidden},
+    {"GET",  "http://localhost:7979/status", map[string]string{"Origin":"http://attacker.example"}, http.StatusForbidden},
+    {"POST", "http://localhost:7979/resume", nil, http.StatusForbidden},
+    {"POST", "http://localhost:7979/resume", map[string]string{"Origin":"http://attacker.example", "X-Hotswap":"1"}, http.StatusForbidden},
+    {"POST", "http://localhost:7979/resume", map[string]string{"X-Hotswap":"1"}, http.StatusOK},
+    {"GET",  "http://localhost:7979/restart", map[string]string{"X-Hotswap":"1"}, http.StatusMethodNotAllowed},
+  }
+  for _, e := range tests {
+    req := httptest.NewRequest(e.method, e.target, nil)
+    for k, v := range e.header {
+      req.Header.Set(k, v)
+    }
+    rsp := httptest.NewRecorder()
+    controlHTTP(rsp, req)
+    assert.Equal(t, e.status, rsp.Code, fmt.Sprintf("%v %v %v", e.method, e.target, e.header))
+  }
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+  "os"
+  "os/exec"
+  "fmt"
+  "sort"
+  "sync"
+  "time"
+  "bytes"
+  "strings"
+  "syscall"
+  "path/filepath"
+)
+
+/**
+ * The exit we're expecting because we asked for it. Anything else is a crash.
+ */
+var expected struct {
+  sync.Mutex
+  pid int
+}
+
+/**
+ * Note that we're about to stop a process on purpose
+ */
+func expectExit(p *os.Process) {
+  expected.Lock()
+  defer expected.Unlock()
+  expected.pid = p.Pid
+}
+
+/**
+ * Determine if a process exited because we asked it to
+ */
+func expectedExit(p *os.Process) bool {
+  expected.Lock()
+  defer expected.Unlock()
+  return expected.pid == p.Pid
+}
+
+/**
+ * Keeps the last lines of output from a generation
+ */
+type lineRing struct {
+  sync.Mutex
+  lines   []string
+  next    int
+  partial []byte
+}
+
+/**
+ * Create a ring which keeps the specified number of lines
+ */
+func newLineRing(n int) *lineRing {
+  return &lineRing{lines:make([]string, 0, n)}
+}
+
+/**
+ * Write output
+ */
+func (r *lineRing) Write(p []byte) (int, error) {
+  r.Lock()
+  defer r.Unlock()
+  r.partial = append(r.partial, p...)
+  for {
+    x := bytes.IndexByte(r.partial, '\n')
+    if x < 0 {
+      break
+    }
+    r.add(string(bytes.TrimRight(r.partial[:x], "\r")))
+    r.partial = r.partial[x+1:]
+  }
+  return len(p), nil
+}
+
+/**
+ * Add a line, replacing the oldest once we're full
+ */
+func (r *lineRing) add(l string) {
+  if len(r.lines) < cap(r.lines) {
+    r.lines = append(r.lines, l)
+  }else if len(r.lines) > 0 {
+    r.lines[r.next] = l
+    r.next = (r.next + 1) % len(r.lines)
+  }
+}
+
+/**
+ * Obtain the lines we have, oldest first, including any unterminated line
+ */
+func (r *lineRing) Lines() []string {
+  r.Lock()
+  defer r.Unlock()
+  l := append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+  if len(r.partial) > 0 {
+    l = append(l, string(r.partial))
+  }
+  return l
+}
+
+/**
+ * What we know about a generation that crashed
+ */
+type crash struct {
+  Name        string
+  Command     string
+  Generation  int
+  Pid         int
+  Started     time.Time
+  Exited      time.Time
+  Err         error
+  Changes     []string
+  Env         []string
+  PrevEnv     []string
+  Output      []string
+}
+
+/**
+ * Describe how a process exited
+ */
+func exitStatus(err error) string {
+  e, ok := err.(*exec.ExitError)
+  if !ok {
+    return err.Error()
+  }
+  w, ok := e.Sys().(syscall.WaitStatus)
+  if !ok || !w.Signaled() {
+    return fmt.Sprintf("exited with status %d", e.ExitCode())
+  }
+  s := fmt.Sprintf("killed by signal %d (%v)", int(w.Signal()), w.Signal())
+  if w.CoreDump() {
+    s += ", core dumped"
+  }
+  return s
+}
+
+/**
+ * Compare environments, ignoring our own variables which change every time.
+ * Only the names of variables are reported, since values are often secrets.
+ */
+func diffEnv(prev, curr []string) []string {
+  parse := func(env []string) map[string]string {
+    m := make(map[string]string)
+    for _, e := range env {
+      if strings.HasPrefix(e, "GO_HOTSWAP_") {
+        continue
+      }
+      if x := strings.Index(e, "="); x > 0 {
+        m[e[:x]] = e[x+1:]
+      }
+    }
+    return m
+  }
+  
+  p, c := parse(prev), parse(curr)
+  var d []string
+  for k, v := range c {
+    if o, ok := p[k]; !ok {
+      d = append(d, "+ "+ k)
+    }else if o != v {
+      d = append(d, "~ "+ k)
+    }
+  }
+  for k := range p {
+    if _, ok := c[k]; !ok {
+      d = append(d, "- "+ k)
+    }
+  }
+  
+  sort.Slice(d, func(i, j int) bool { return d[i][2:] < d[j][2:] })
+  return d
+}
+
+/**
+ * Write a crash report into the specified directory and return its path
+ */
+func (c crash) Write(dir string) (string, error) {
+  var b bytes.Buffer
+  
+  fmt.Fprintf(&b, "Command:    %v\n", c.Command)
+  fmt.Fprintf(&b, "Generation: %d\n", c.Generation)
+  fmt.Fprintf(&b, "PID:        %d\n", c.Pid)
+  fmt.Fprintf(&b, "Started:    %v\n", c.Started.Format(time.RFC3339))
+  fmt.Fprintf(&b, "Exited:     %v\n", c.Exited.Format(time.RFC3339))
+  fmt.Fprintf(&b, "Uptime:     %v\n", c.Exited.Sub(c.Started).Round(time.Millisecond))
+  fmt.Fprintf(&b, "Status:     %v\n", exitStatus(c.Err))
+  
+  fmt.Fprintf(&b, "\nFiles changed since the previous generation:\n")
+  if len(c.Changes) < 1 {
+    fmt.Fprintf(&b, "  (none)\n")
+  }
+  for _, e := range c.Changes {
+    fmt.Fprintf(&b, "  %v\n", e)
+  }
+  
+  fmt.Fprintf(&b, "\nEnvironment changes since the previous generation:\n")
+  d := diffEnv(c.PrevEnv, c.Env)
+  if len(d) < 1 {
+    fmt.Fprintf(&b, "  (none)\n")
+  }
+  for _, e := range d {
+    fmt.Fprintf(&b, "  %v\n", e)
+  }
+  
+  fmt.Fprintf(&b, "\nLast %d lines of output:\n", len(c.Output))
+  for _, e := range c.Output {
+    fmt.Fprintf(&b, "%v\n", e)
+  }
+  
+  err := os.MkdirAll(dir, 0755)
+  if err != nil {
+    return "", err
+  }
+  p := filepath.Join(dir, fmt.Sprintf("crash-%v-%d-%v.txt", c.Name, c.Generation, c.Exited.Format("20060102T150405")))
+  err = writeFile(p, b.Bytes(), 0600) // output may well contain secrets
+  if err == nil {
+    err = chown(p)
+  }
+  return p, err
+}
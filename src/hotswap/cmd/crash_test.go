@@ -0,0 +1,24 @@
+package main
+
+import (
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestLineRing(t *testing.T) {
+  r := newLineRing(3)
+  
+  r.Write([]byte("one\ntwo\n"))
+  assert.Equal(t, []string{"one", "two"}, r.Lines())
+  
+  r.Write([]byte("three\r\nfour\nfi"))
+  r.Write([]byte("ve\nsix"))
+  assert.Equal(t, []string{"three", "four", "five", "six"}, r.Lines())
+}
+
+func TestDiffEnv(t *testing.T) {
+  prev := []string{"A=1", "B=2", "C=3", "GO_HOTSWAP_GENERATION=1"}
+  curr := []string{"A=1", "B=4", "D=5", "GO_HOTSWAP_GENERATION=2"}
+  assert.Equal(t, []string{"~ B", "- C", "+ D"}, diffEnv(prev, curr))
+  assert.Empty(t, diffEnv(prev, prev))
+}
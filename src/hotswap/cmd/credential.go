@@ -0,0 +1,120 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "strconv"
+  "syscall"
+  "os/user"
+)
+
+/**
+ * Determine the credentials to run the managed process with. Users and groups
+ * may be given by name or ID; a user's own group and supplementary groups are
+ * used unless a group is specified. If neither is given, this returns nil and
+ * the process runs as we do.
+ */
+func credential(u, g string) (*syscall.Credential, error) {
+  if u == "" && g == "" {
+    return nil, nil
+  }
+  
+  c := &syscall.Credential{Uid:uint32(os.Getuid()), Gid:uint32(os.Getgid()), NoSetGroups:true}
+  
+  if u != "" {
+    usr, err := user.Lookup(u)
+    if _, ok := err.(user.UnknownUserError); ok {
+      usr, err = user.LookupId(u)
+    }
+    if err != nil {
+      return nil, fmt.Errorf("Unknown user: %v", u)
+    }
+    c.Uid, err = parseID(usr.Uid)
+    if err != nil {
+      return nil, err
+    }
+    c.Gid, err = parseID(usr.Gid)
+    if err != nil {
+      return nil, err
+    }
+    gids, err := usr.GroupIds()
+    if err == nil { // not every platform can tell us, in which case there are none
+      for _, e := range gids {
+        id, err := parseID(e)
+        if err != nil {
+          return nil, err
+        }
+        c.Groups = append(c.Groups, id)
+      }
+    }
+    c.NoSetGroups = false // drop our own supplementary groups
+  }
+  
+  if g != "" {
+    grp, err := user.LookupGroup(g)
+    if _, ok := err.(user.UnknownGroupError); ok {
+      grp, err = user.LookupGroupId(g)
+    }
+    if err != nil {
+      return nil, fmt.Errorf("Unknown group: %v", g)
+    }
+    c.Gid, err = parseID(grp.Gid)
+    if err != nil {
+      return nil, err
+    }
+    if u != "" {
+      c.Groups = nil // the group specified replaces the user's own
+    }
+  }
+  
+  return c, nil
+}
+
+/**
+ * Parse a user or group ID
+ */
+func parseID(s string) (uint32, error) {
+  n, err := strconv.ParseUint(s, 10, 32)
+  if err != nil {
+    return 0, fmt.Errorf("Invalid ID: %v", s)
+  }
+  return uint32(n), nil
+}
+
+/**
+ * Create a new file. We may be writing into a directory the managed user
+ * owns, so we never follow or reuse anything they might have put in our way.
+ */
+func createFile(p string, mode os.FileMode) (*os.File, error) {
+  return os.OpenFile(p, os.O_CREATE | os.O_EXCL | os.O_WRONLY | syscall.O_NOFOLLOW, mode)
+}
+
+/**
+ * Write a new file, as above
+ */
+func writeFile(p string, data []byte, mode os.FileMode) error {
+  f, err := createFile(p, mode)
+  if err != nil {
+    return err
+  }
+  _, err = f.Write(data)
+  if cerr := f.Close(); err == nil {
+    err = cerr
+  }
+  if err != nil {
+    os.Remove(p)
+  }
+  return err
+}
+
+/**
+ * Give a file we created on behalf of the managed process to the user it runs
+ * as, so that files such as logs and pidfiles can be managed by that user.
+ * This does nothing if the process runs as we do.
+ */
+func chown(p string) error {
+  if conf.Credential == nil {
+    return nil
+  }
+  return os.Lchown(p, int(conf.Credential.Uid), int(conf.Credential.Gid))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+  "os"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestCredential(t *testing.T) {
+  
+  c, err := credential("", "")
+  assert.Nil(t, err)
+  assert.Nil(t, c)
+  
+  c, err = credential("0", "")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, uint32(0), c.Uid)
+  assert.Equal(t, uint32(0), c.Gid)
+  assert.False(t, c.NoSetGroups)
+  
+  c, err = credential("", "0")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, uint32(os.Getuid()), c.Uid)
+  assert.Equal(t, uint32(0), c.Gid)
+  assert.True(t, c.NoSetGroups)
+  
+  _, err = credential("no-such-user-here", "")
+  assert.NotNil(t, err)
+  _, err = credential("", "no-such-group-here")
+  assert.NotNil(t, err)
+  
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "net"
+  "flag"
+  "bufio"
+  "strings"
+)
+
+// where the control socket is, unless told otherwise
+const defaultControlSocket = ".hotswap.sock"
+
+/**
+ * Talk to a running hotswap over its control socket. The exit status reflects
+ * whether the command succeeded.
+ */
+func ctl(args []string) int {
+  cmdline := flag.NewFlagSet("hotswap ctl", flag.ExitOnError)
+  fSocket := cmdline.String("socket", defaultControlSocket, "The control socket of the hotswap to talk to.")
+  cmdline.Usage = func() {
+    fmt.Fprintf(os.Stderr, "Usage: hotswap ctl [-socket <path>] status|restart|stop|start|pause|resume|logs [--follow]|quit\n")
+    cmdline.PrintDefaults()
+  }
+  cmdline.Parse(args)
+  
+  if cmdline.NArg() < 1 {
+    cmdline.Usage()
+    return 2
+  }
+  
+  conn, err := net.Dial("unix", *fSocket)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "hotswap ctl: %v\n", err)
+    return 1
+  }
+  defer conn.Close()
+  
+  _, err = fmt.Fprintf(conn, "%s\n", strings.Join(cmdline.Args(), " "))
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "hotswap ctl: %v\n", err)
+    return 1
+  }
+  
+  r := bufio.NewReader(conn)
+  line, err := r.ReadString('\n')
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "hotswap ctl: %v\n", err)
+    return 1
+  }
+  
+  if line = strings.TrimSpace(line); line != "ok" {
+    fmt.Fprintf(os.Stderr, "hotswap ctl: %v\n", strings.TrimPrefix(line, "error "))
+    return 1
+  }
+  
+  io.Copy(os.Stdout, r)
+  return 0
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "strings"
+  "path/filepath"
+)
+
+import (
+  "github.com/fsnotify/fsnotify"
+)
+
+/**
+ * An environment which remembers the order variables were defined in
+ */
+type envList struct {
+  keys  []string
+  vals  map[string]string
+}
+
+/**
+ * Create an environment from 'KEY=VALUE' pairs
+ */
+func newEnvList(env []string) *envList {
+  e := &envList{vals:make(map[string]string)}
+  for _, v := range env {
+    if x := strings.Index(v, "="); x > 0 {
+      e.Set(v[:x], v[x+1:])
+    }
+  }
+  return e
+}
+
+/**
+ * Set a variable
+ */
+func (e *envList) Set(k, v string) {
+  if _, ok := e.vals[k]; !ok {
+    e.keys = append(e.keys, k)
+  }
+  e.vals[k] = v
+}
+
+/**
+ * Look up a variable for interpolation. Variables we don't have are looked up
+ * in our own environment, even if it isn't being passed on.
+ */
+func (e *envList) Lookup(k string) string {
+  if v, ok := e.vals[k]; ok {
+    return v
+  }
+  return os.Getenv(k)
+}
+
+/**
+ * Obtain 'KEY=VALUE' pairs
+ */
+func (e *envList) List() []string {
+  l := make([]string, 0, len(e.keys))
+  for _, k := range e.keys {
+    l = append(l, k +"="+ e.vals[k])
+  }
+  return l
+}
+
+/**
+ * Build the environment for the managed process: our own environment unless
+ * it's cleared, then env files in order, then variables from the command line.
+ */
+func environment() ([]string, error) {
+  var e *envList
+  if conf.EnvClear {
+    e = newEnvList(nil)
+  }else{
+    e = newEnvList(os.Environ())
+  }
+  
+  for _, f := range conf.EnvFiles {
+    data, err := os.ReadFile(f)
+    if err != nil {
+      return nil, err
+    }
+    err = parseEnv(string(data), e)
+    if err != nil {
+      return nil, fmt.Errorf("%v: %v", f, err)
+    }
+  }
+  
+  for _, v := range conf.Env {
+    x := strings.Index(v, "=")
+    e.Set(v[:x], v[x+1:])
+  }
+  
+  return e.List(), nil
+}
+
+/**
+ * Parse an env file into an environment. This accepts the usual dotenv
+ * syntax: 'KEY=VALUE' lines, optionally prefixed by 'export', blank lines and
+ * '#' comments. Values may be unquoted, in single quotes, which are taken
+ * literally, or in double quotes, which may span lines and support the escapes
+ * \n, \r, \t, \", \\ and \$. Unquoted and double-quoted values are interpolated:
+ * $VAR, ${VAR} and ${VAR:-default} are replaced by variables defined earlier,
+ * or failing that, by our own.
+ */
+func parseEnv(s string, e *envList) error {
+  line := 1
+  i := 0
+  
+  for i < len(s) {
+    switch s[i] {
+      case '\n':
+        line++
+        i++
+        continue
+      case ' ', '\t', '\r':
+        i++
+        continue
+      case '#':
+        for i < len(s) && s[i] != '\n' {
+          i++
+        }
+        continue
+    }
+    
+    // the key
+    start := i
+    for i < len(s) && s[i] != '=' && s[i] != '\n' {
+      i++
+    }
+    if i >= len(s) || s[i] != '=' {
+      return fmt.Errorf("Line %d: expected KEY=VALUE", line)
+    }
+    k := strings.TrimSpace(s[start:i])
+    k = strings.TrimSpace(strings.TrimPrefix(k, "export "))
+    if !validEnvKey(k) {
+      return fmt.Errorf("Line %d: invalid variable name: %q", line, k)
+    }
+    i++
+    for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+      i++
+    }
+    
+    // the value
+    var v strings.Builder
+    at := line
+    switch {
+      case i < len(s) && s[i] == '\'':
+        i++
+        for ; i < len(s) && s[i] != '\''; i++ {
+          if s[i] == '\n' {
+            line++
+          }
+          v.WriteByte(s[i])
+        }
+        if i >= len(s) {
+          return fmt.Errorf("Line %d: unterminated quote", at)
+        }
+        i++
+        
+      case i < len(s) && s[i] == '"':
+        i++
+        for ; i < len(s) && s[i] != '"'; i++ {
+          switch s[i] {
+            case '\n':
+              line++
+              v.WriteByte('\n')
+            case '\\':
+              if i + 1 >= len(s) {
+                break
+              }
+              i++
+              switch s[i] {
+                case 'n':
+                  v.WriteByte('\n')
+                case 'r':
+                  v.WriteByte('\r')
+                case 't':
+                  v.WriteByte('\t')
+                case '"', '\\', '$':
+                  v.WriteByte(s[i])
+                default:
+                  v.WriteByte('\\')
+                  v.WriteByte(s[i])
+              }
+            case '$':
+              r, n, err := expandEnv(s, i, e)
+              if err != nil {
+                return fmt.Errorf("Line %d: %v", line, err)
+              }
+              v.WriteString(r)
+              i = n - 1
+            default:
+              v.WriteByte(s[i])
+          }
+        }
+        if i >= len(s) {
+          return fmt.Errorf("Line %d: unterminated quote", at)
+        }
+        i++
+        
+      default:
+        start = i
+        for i < len(s) && s[i] != '\n' {
+          if s[i] == '#' && i > start && (s[i-1] == ' ' || s[i-1] == '\t') {
+            break // a trailing comment
+          }
+          i++
+        }
+        raw := strings.TrimSpace(s[start:i])
+        for j := 0; j < len(raw); j++ {
+          if raw[j] != '$' {
+            v.WriteByte(raw[j])
+            continue
+          }
+          r, n, err := expandEnv(raw, j, e)
+          if err != nil {
+            return fmt.Errorf("Line %d: %v", line, err)
+          }
+          v.WriteString(r)
+          j = n - 1
+        }
+    }
+    
+    // nothing but a comment may follow
+    for i < len(s) && s[i] != '\n' {
+      if s[i] == '#' {
+        for i < len(s) && s[i] != '\n' {
+          i++
+        }
+        break
+      }
+      if s[i] != ' ' && s[i] != '\t' && s[i] != '\r' {
+        return fmt.Errorf("Line %d: unexpected characters after value", line)
+      }
+      i++
+    }
+    
+    e.Set(k, v.String())
+  }
+  
+  return nil
+}
+
+/**
+ * Expand the variable reference at s[i], which is a '$'. This returns the
+ * expansion and the index following the reference.
+ */
+func expandEnv(s string, i int, e *envList) (string, int, error) {
+  i++
+  if i < len(s) && s[i] == '{' {
+    x := strings.IndexByte(s[i:], '}')
+    if x < 0 {
+      return "", 0, fmt.Errorf("unterminated variable reference")
+    }
+    ref, def := s[i+1:i+x], ""
+    hasDefault := false
+    if d := strings.Index(ref, ":-"); d >= 0 {
+      ref, def, hasDefault = ref[:d], ref[d+2:], true
+    }
+    if !validEnvKey(ref) {
+      return "", 0, fmt.Errorf("invalid variable reference: %q", s[i-1:i+x+1])
+    }
+    v := e.Lookup(ref)
+    if v == "" && hasDefault {
+      v = def
+    }
+    return v, i + x + 1, nil
+  }
+  
+  start := i
+  for i < len(s) && isEnvKeyChar(s[i], i == start) {
+    i++
+  }
+  if i == start {
+    return "$", i, nil // a lone dollar sign
+  }
+  return e.Lookup(s[start:i]), i, nil
+}
+
+/**
+ * Determine if a string is a valid variable name
+ */
+func validEnvKey(k string) bool {
+  if k == "" {
+    return false
+  }
+  for i := 0; i < len(k); i++ {
+    if !isEnvKeyChar(k[i], i == 0) {
+      return false
+    }
+  }
+  return true
+}
+
+/**
+ * Determine if a character may appear in a variable name
+ */
+func isEnvKeyChar(c byte, first bool) bool {
+  return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+/**
+ * Restart the managed process when an env file changes. We watch the
+ * directories the files are in, since editors often replace files rather than
+ * writing to them.
+ */
+func watchEnvFiles(files []string) error {
+  watcher, err := fsnotify.NewWatcher()
+  if err != nil {
+    return err
+  }
+  
+  names := make(map[string]struct{})
+  dirs := make(map[string]struct{})
+  for _, e := range files {
+    names[e] = struct{}{}
+    d := filepath.Dir(e)
+    if _, ok := dirs[d]; ok {
+      continue
+    }
+    dirs[d] = struct{}{}
+    err = watcher.Add(d)
+    if err != nil {
+      watcher.Close()
+      return err
+    }
+  }
+  
+  go func() {
+    for {
+      select {
+        case e, ok := <- watcher.Events:
+          if !ok {
+            return
+          }
+          if _, ok := names[filepath.Clean(e.Name)]; !ok || e.Op & (fsnotify.Create | fsnotify.Write | fsnotify.Rename | fsnotify.Remove) == 0 {
+            continue
+          }
+          if conf.Verbose { fmt.Printf("--> env file %v\n", e) }
+          if paused() {
+            continue
+          }
+          noteChange(e.Name)
+          event()
+        case err, ok := <- watcher.Errors:
+          if !ok {
+            return
+          }
+          logf("Could not watch env files: %v", err)
+      }
+    }
+  }()
+  
+  return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestParseEnv(t *testing.T) {
+  e := newEnvList([]string{"BASE=/srv"})
+  err := parseEnv(`
+# a comment
+PLAIN=value
+export EXPORTED = spaced out  # trailing comment
+SINGLE='$BASE is literal # here'
+DOUBLE="line\none\t\"quoted\" \$BASE"
+MULTI="first
+second"
+EXPAND=$BASE/app
+BRACES=${BASE}/bin
+DEFAULT=${MISSING:-fallback}
+HASH=a#b
+LONE=cost $ 5
+EMPTY=
+`, e)
+  if !assert.Nil(t, err) { return }
+  
+  assert.Equal(t, "value", e.vals["PLAIN"])
+  assert.Equal(t, "spaced out", e.vals["EXPORTED"])
+  assert.Equal(t, "$BASE is literal # here", e.vals["SINGLE"])
+  assert.Equal(t, "line\none\t\"quoted\" $BASE", e.vals["DOUBLE"])
+  assert.Equal(t, "first\nsecond", e.vals["MULTI"])
+  assert.Equal(t, "/srv/app", e.vals["EXPAND"])
+  assert.Equal(t, "/srv/bin", e.vals["BRACES"])
+  assert.Equal(t, "fallback", e.vals["DEFAULT"])
+  assert.Equal(t, "a#b", e.vals["HASH"])
+  assert.Equal(t, "cost $ 5", e.vals["LONE"])
+  assert.Equal(t, "", e.vals["EMPTY"])
+  assert.Equal(t, "BASE=/srv", e.List()[0])
+  
+  for _, s := range []string{"NOVALUE", "1BAD=x", "Q='open", "Q=\"open", "Q='a' b", "R=${OPEN"} {
+    err = parseEnv(s, newEnvList(nil))
+    assert.NotNil(t, err, s)
+  }
+}
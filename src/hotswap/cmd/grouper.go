@@ -50,6 +50,23 @@ func (g *grouper) Invoke() error {
   return r
 }
 
+/**
+ * Cancel the action without invoking it. If the action has already been
+ * fired, this method does nothing.
+ */
+func (g *grouper) Cancel() {
+  g.Lock()
+  defer g.Unlock()
+  if g.cancel != nil {
+    close(g.cancel)
+    g.cancel = nil
+  }
+  if g.timer != nil {
+    g.timer.Stop()
+  }
+  g.action = nil
+}
+
 /**
  * Wait asynchronously and fire the action after the specified duration
  */
@@ -10,20 +10,32 @@ import (
   "flag"
   "sync"
   "path"
+  "path/filepath"
   "bytes"
   "strings"
+  "strconv"
   "runtime"
   "syscall"
 )
 
 import (
+  "hotswap"
   "github.com/fsnotify/fsnotify"
 )
 
 var lock sync.Mutex
 var proc *os.Process
+var pgroup *cgroup
 var group *grouper
+var builds *builder
+var rules []*rule
+var logs *logFile
 var generation int
+var prevEnv = os.Environ()
+var changes = make(chan struct{}, 1)
+
+// how long to wait before verifying a command again if nothing changes
+const verifyRetry = time.Second * 5
 
 var conf struct {
   Cmd         string
@@ -32,6 +44,31 @@ var conf struct {
   DumpOnExit  bool
   Delay       time.Duration
   Signal      os.Signal
+  Verify      *hotswap.Verification
+  Pidfile     string
+  ChildPidfile string
+  Build       string
+  Prefix      bool
+  Timestamps  bool
+  Color       bool
+  TTY         bool
+  Keys        bool
+  Control     string
+  StopTimeout time.Duration
+  Cgroup      string
+  Rlimits     []rlimit
+  Usage       usagePolicy
+  Probes      []*probe
+  ProbeGrace  time.Duration
+  CrashLines  int
+  CrashDir    string
+  Env         []string
+  EnvFiles    []string
+  EnvClear    bool
+  Credential  *syscall.Credential
+  Dir         string
+  Umask       *int
+  Chroot      string
 }
 
 /**
@@ -58,7 +95,15 @@ func (s *flagList) String() string {
  * You know what it does.
  */
 func main() {
-  var watchDirs, watchFilters flagList
+  var watchDirs, watchFilters, watchRules, limits, probes, env, envFiles flagList
+  var err error
+  
+  if v := os.Getenv(envExec); v != "" {
+    os.Exit(trampoline(v)) // we're about to become the managed process
+  }
+  if len(os.Args) > 1 && os.Args[1] == "ctl" {
+    os.Exit(ctl(os.Args[2:]))
+  }
   
   pname := os.Args[0]
   if x := strings.LastIndex(pname, "/"); x > 0 {
@@ -71,21 +116,88 @@ func main() {
   fVerbose      := cmdline.Bool     ("verbose",       false,          "Enable verbose debugging mode.")
   fDebug        := cmdline.Bool     ("debug",         false,          "Enable debugging mode.")
   fDumpStack    := cmdline.Bool     ("debug:stack",   false,          "Dump the stack on interrupt before exiting.")
+  fChecksum     := cmdline.Bool     ("verify:checksum", false,        "Require a SHA-256 checksum file named '<command>.sha256' to match before starting the command.")
+  fPublicKey    := cmdline.String   ("verify:key",    "",             "Require an ed25519 signature file named '<command>.sig' made with the key in this file before starting the command.")
+  fVerifyWait   := cmdline.Duration ("verify:timeout", time.Second * 10, "How long to wait for the command to be completely written and verified.")
+  fPidfile      := cmdline.String   ("pidfile",       "",             "Write the PID of hotswap itself to this file.")
+  fChildPidfile := cmdline.String   ("child-pidfile", "",             "Write the PID of the current generation of the managed process to this file. It is removed while no generation is running.")
+  fPrefix       := cmdline.Bool     ("prefix",        false,          "Prefix each line of output from the managed process with its name and generation.")
+  fTimestamps   := cmdline.Bool     ("timestamps",    false,          "Prefix each line of output with the time it was written.")
+  fColor        := cmdline.String   ("color",         "auto",         "Colorize hotswap's own messages: 'auto', 'always' or 'never'.")
+  fLogDir       := cmdline.String   ("log-dir",       "",             "Also write output from the managed process to log files in this directory.")
+  fLogSize      := cmdline.Int64    ("log-max-size",  0,              "Rotate log files when they exceed this many megabytes.")
+  fLogAge       := cmdline.Duration ("log-max-age",   0,              "Rotate log files when they have been written to for this long.")
+  fLogKeep      := cmdline.Int      ("log-keep",      0,              "Keep at most this many log files, removing the oldest.")
+  fLogCompress  := cmdline.Bool     ("log-compress",  false,          "Compress log files once they have been rotated.")
+  fTTY          := cmdline.Bool     ("tty",           false,          "Linux only: run the managed process on a pseudo-terminal and forward input to it, including Ctrl-C. Keyboard commands are enabled and prefixed with Ctrl-T; quit with Ctrl-T q.")
+  fKeys         := cmdline.Bool     ("keys",          false,          "Enable keyboard commands; press '?' for help. With -tty, they're always enabled and prefixed with Ctrl-T.")
+  fControl      := cmdline.String   ("control",       "",             "Accept control commands on a unix socket at this path; use 'hotswap ctl' to send them. Try '"+ defaultControlSocket +"'.")
+  fControlHTTP  := cmdline.String   ("control:http",  "",             "Also accept control commands over HTTP at this address, e.g. 'localhost:7979'. Only loopback addresses are allowed; POST commands need an 'X-Hotswap' header.")
+  fStopTimeout  := cmdline.Duration ("stop-timeout",  time.Second * 10, "How long to wait for the managed process to exit on shutdown before killing it.")
+  fCgroup       := cmdline.Bool     ("cgroup",        false,          "Linux only: run each generation in its own cgroup and kill everything in it on restart, including daemonized descendants.")
+  fCgroupParent := cmdline.String   ("cgroup:parent", "",             "The cgroup v2 directory in which to create groups for each generation. Defaults to the cgroup hotswap is running in.")
+  fMaxRSS       := cmdline.String   ("max-rss",       "",             "Linux only: restart the managed process when its resident memory exceeds this size, e.g. '512M'.")
+  fMaxRSSFor    := cmdline.Duration ("max-rss:for",   time.Second * 10, "How long memory use must exceed -max-rss before the process is restarted.")
+  fWarnCPU      := cmdline.Float64  ("warn-cpu",      0,              "Linux only: warn when the managed process uses more than this percentage of a CPU, e.g. '90'.")
+  fWarnCPUFor   := cmdline.Duration ("warn-cpu:for",  time.Second * 30, "How long CPU use must exceed -warn-cpu before a warning is issued.")
+  fSample       := cmdline.Duration ("sample",        time.Second,    "How often to sample resource usage of the managed process.")
+  fProbeEvery   := cmdline.Duration ("probe:interval", time.Second * 5, "How often to run liveness probes, unless a probe says otherwise.")
+  fProbeTimeout := cmdline.Duration ("probe:timeout", time.Second * 2, "How long a liveness probe may take, unless a probe says otherwise.")
+  fProbeFails   := cmdline.Int      ("probe:failures", 3,             "How many times in a row a liveness probe must fail before the process is restarted, unless a probe says otherwise.")
+  fProbeGrace   := cmdline.Duration ("probe:grace",   time.Second * 10, "How long to give the managed process to start up before probing it.")
+  fCrashLines   := cmdline.Int      ("crash:lines",   100,            "Write a crash report with this many lines of output when the managed process exits unexpectedly. Use 0 to disable crash reports.")
+  fCrashDir     := cmdline.String   ("crash:dir",     "",             "The directory to write crash reports to. Defaults to the log directory or the system temporary directory.")
+  fEnvClear     := cmdline.Bool     ("env:clear",     false,          "Don't pass hotswap's own environment on to the managed process; only variables from -env and -env-file are set.")
+  fUser         := cmdline.String   ("user",          "",             "Run the managed process as this user, given by name or ID. Log files and the child PID file are owned by the user too.")
+  fGroup        := cmdline.String   ("group",         "",             "Run the managed process as this group, given by name or ID. Defaults to the group of the user given by -user.")
+  fDir          := cmdline.String   ("dir",           "",             "Run the managed process in this working directory. Inside a chroot, this is relative to the new root.")
+  fUmask        := cmdline.String   ("umask",         "",             "Run the managed process with this umask, in octal, e.g. '027'.")
+  fChroot       := cmdline.String   ("chroot",        "",             "Run the managed process with this directory as its root. The command must then be an absolute path inside it.")
+  fBuild        := cmdline.String   ("build",         "",             "A shell command which builds the managed process. It runs before each generation is started; changes during a build cancel it.")
   cmdline.Var    (&watchDirs,        "watch",                         "Watch a directory tree for changes. Provide this flag repeatedly to watch multiple directories.")
   cmdline.Var    (&watchFilters,     "filter",                        "Watch only files with specific name patterns for changes. Specify a glob pattern, e.g. '*.go'.")
+  cmdline.Var    (&limits,           "rlimit",                        "Set a resource limit for the managed process, e.g. 'nofile=4096', 'core=unlimited' or 'as=1G:2G'. Provide this flag repeatedly to set multiple limits.")
+  cmdline.Var    (&probes,           "probe",                         "Restart the managed process when it stops responding, e.g. 'http:http://localhost:8080/health', 'tcp:localhost:8080', 'exec:./check.sh' or 'heartbeat' for processes which call hotswap.Heartbeat. Options may follow the kind, e.g. 'tcp,interval=1s,timeout=1s,failures=5:localhost:8080'. Provide this flag repeatedly to use multiple probes.")
+  cmdline.Var    (&env,              "env",                           "Set an environment variable for the managed process, e.g. 'PORT=8080'. This overrides env files. Provide this flag repeatedly to set multiple variables.")
+  cmdline.Var    (&envFiles,         "env-file",                      "Load environment variables for the managed process from a dotenv file. The process is restarted when the file changes. Provide this flag repeatedly to load multiple files; later files override earlier ones.")
+  cmdline.Var    (&watchRules,       "rule",                          "Take a specific action when files matching a pattern change, e.g. '*.css=run:make assets' or 'config/*.yaml=signal:HUP'. Files matching no rule restart the process.")
   cmdline.Parse(os.Args[1:])
   
   conf.Cmd = pname
   conf.Debug = *fDebug
   conf.Verbose = *fVerbose
   conf.DumpOnExit = *fDumpStack
+  conf.Prefix = *fPrefix
+  conf.Timestamps = *fTimestamps
+  conf.TTY = *fTTY
+  conf.Keys = *fKeys || *fTTY // Ctrl-C goes to the process, so there must be some other way out
+  conf.StopTimeout = *fStopTimeout
+  
+  if conf.TTY {
+    err = checkPty()
+    if err != nil {
+      panic(err)
+    }
+  }
+  
+  switch {
+    case strings.EqualFold(*fColor, "auto"):
+      conf.Color = isTerminal(os.Stdout)
+    case strings.EqualFold(*fColor, "always"):
+      conf.Color = true
+    case strings.EqualFold(*fColor, "never"):
+      conf.Color = false
+    default:
+      panic(fmt.Errorf("Unknown color mode: %v", *fColor))
+  }
+  conf.Build = *fBuild
   
   if *fDelay < time.Millisecond * 10 {
     conf.Delay = time.Millisecond * 10
   }else{
     conf.Delay = *fDelay
   }
-  fmt.Printf("%v: Event grouping delay: %v\n", conf.Cmd, conf.Delay)
+  logf("Event grouping delay: %v", conf.Delay)
   
   switch {
     case strings.EqualFold(*fSignal, "INT"):
@@ -96,31 +208,213 @@ func main() {
       panic(fmt.Errorf("Unknown signal: %v", *fSignal))
   }
   
+  if *fChecksum || *fPublicKey != "" {
+    conf.Verify = &hotswap.Verification{Checksum:*fChecksum, Timeout:*fVerifyWait}
+    if *fPublicKey != "" {
+      conf.Verify.PublicKey, err = hotswap.LoadPublicKey(*fPublicKey)
+      if err != nil {
+        panic(err)
+      }
+    }
+  }
+  
   if len(watchDirs) > 0 {
-    fmt.Printf("%v: Watching roots:\n", conf.Cmd)
+    logf("Watching roots:")
     for _, e := range watchDirs {
       fmt.Printf("  -> %s\n", e)
     }
   }
   
+  for _, e := range watchRules {
+    r, err := parseRule(e)
+    if err != nil {
+      panic(err)
+    }
+    rules = append(rules, r)
+  }
+  if len(rules) > 0 {
+    logf("Rules:")
+    for _, e := range rules {
+      fmt.Printf("  -> %v\n", e)
+    }
+  }
+  
   args := cmdline.Args()
   if len(args) < 2 {
-    fmt.Printf("%v: Usage hotswap <command> [args]\n", conf.Cmd)
+    logf("Usage hotswap <command> [args]")
     return
   }
   
   c := args[0]
   a := args[1:]
   
-  c, err := resolve(c)
+  conf.Credential, err = credential(*fUser, *fGroup)
   if err != nil {
     panic(err)
   }
+  conf.Dir = *fDir
+  conf.Chroot = *fChroot
+  if *fUmask != "" {
+    m, err := strconv.ParseUint(*fUmask, 8, 32)
+    if err != nil || m > 0777 {
+      panic(fmt.Errorf("Invalid umask: %v", *fUmask))
+    }
+    v := int(m)
+    conf.Umask = &v
+  }
+  
+  if conf.Chroot != "" && !path.IsAbs(c) {
+    panic(fmt.Errorf("Command must be an absolute path inside the chroot: %v", c))
+  }
+  if conf.Build == "" { // otherwise it may not exist until it's built
+    c, err = locate(c)
+    if err != nil {
+      panic(err)
+    }
+  }
+  
+  if *fCgroup {
+    conf.Cgroup = *fCgroupParent
+    if conf.Cgroup == "" {
+      conf.Cgroup, err = cgroupParent()
+      if err != nil {
+        panic(err)
+      }
+    }
+    logf("Running generations in cgroups under: %v", conf.Cgroup)
+  }
+  
+  for _, e := range limits {
+    r, err := parseRlimit(e)
+    if err != nil {
+      panic(err)
+    }
+    conf.Rlimits = append(conf.Rlimits, r)
+  }
+  
+  conf.Usage = usagePolicy{Interval:*fSample, MaxRSSFor:*fMaxRSSFor, WarnCPU:*fWarnCPU, WarnCPUFor:*fWarnCPUFor}
+  if *fMaxRSS != "" {
+    conf.Usage.MaxRSS, err = parseSize(*fMaxRSS)
+    if err != nil {
+      panic(err)
+    }
+  }
+  if conf.Usage.Interval <= 0 {
+    conf.Usage.Interval = time.Second
+  }
+  
+  conf.EnvClear = *fEnvClear
+  for _, e := range env {
+    if x := strings.Index(e, "="); x < 1 || !validEnvKey(e[:x]) {
+      panic(fmt.Errorf("Invalid environment variable, expected 'KEY=VALUE': %v", e))
+    }
+    conf.Env = append(conf.Env, e)
+  }
+  for _, e := range envFiles {
+    f, err := filepath.Abs(e)
+    if err != nil {
+      panic(err)
+    }
+    conf.EnvFiles = append(conf.EnvFiles, f)
+  }
+  if len(conf.EnvFiles) > 0 {
+    _, err = environment() // make sure they're usable before we start
+    if err != nil {
+      panic(err)
+    }
+    err = watchEnvFiles(conf.EnvFiles)
+    if err != nil {
+      panic(err)
+    }
+  }
+  
+  conf.ProbeGrace = *fProbeGrace
+  conf.CrashLines = *fCrashLines
+  conf.CrashDir = *fCrashDir
+  if conf.CrashDir == "" {
+    conf.CrashDir = os.TempDir()
+    if *fLogDir != "" {
+      conf.CrashDir = *fLogDir
+    }
+  }
+  for _, e := range probes {
+    p, err := parseProbe(e, probe{interval:*fProbeEvery, timeout:*fProbeTimeout, failures:*fProbeFails})
+    if err != nil {
+      panic(err)
+    }
+    if p.kind == "heartbeat" && beats == nil {
+      name := fmt.Sprintf("hotswap-%d.heartbeat", os.Getpid())
+      sock, addr := path.Join(os.TempDir(), name), path.Join(os.TempDir(), name)
+      if conf.Chroot != "" {
+        sock, addr = path.Join(conf.Chroot, name), "/"+ name // it can only see what's inside its root
+      }
+      beats, err = listenHeartbeats(sock, addr)
+      if err != nil {
+        panic(err)
+      }
+    }
+    conf.Probes = append(conf.Probes, p)
+  }
+  
+  if *fLogDir != "" {
+    logs, err = newLogFile(*fLogDir, path.Base(c))
+    if err != nil {
+      panic(err)
+    }
+    logs.maxSize = *fLogSize << 20
+    logs.maxAge = *fLogAge
+    logs.keep = *fLogKeep
+    logs.compress = *fLogCompress
+  }
+  
+  for _, e := range []string{*fPidfile, *fChildPidfile} {
+    if e != "" {
+      err = checkPidfile(e)
+      if err != nil {
+        logf("%v", err)
+        os.Exit(1)
+      }
+    }
+  }
+  
+  conf.Pidfile = *fPidfile
+  conf.ChildPidfile = *fChildPidfile
+  if conf.Pidfile != "" {
+    err = writePidfile(conf.Pidfile, os.Getpid(), false)
+    if err != nil {
+      panic(err)
+    }
+  }
+  
+  if *fControl != "" {
+    err = serveControl(*fControl)
+    if err != nil {
+      panic(err)
+    }
+    conf.Control = *fControl
+  }
+  if *fControlHTTP != "" {
+    err = serveControlHTTP(*fControlHTTP)
+    if err != nil {
+      panic(err)
+    }
+  }
   
   go monitor(watchDirs, watchFilters)
   go signals()
   
+  if conf.Keys {
+    rawTerminal()
+    pumpInput()
+  }
+  
+  if conf.Build != "" {
+    builds = newBuilder(conf.Build)
+    startupBuild()
+  }
+  
   for {
+    waitStarted()
     run(c, a)
   }
 }
@@ -142,6 +436,35 @@ func resolve(c string) (string, error) {
   return c, nil
 }
 
+/**
+ * Find the command to run. Inside a chroot, it's an absolute path we only
+ * make sure exists.
+ */
+func locate(c string) (string, error) {
+  if conf.Chroot != "" {
+    _, err := os.Stat(hostPath(c))
+    return c, err
+  }
+  c, err := resolve(c)
+  if err != nil {
+    return "", err
+  }
+  if conf.Dir != "" {
+    return filepath.Abs(c) // it's relative to us, not to where the process runs
+  }
+  return c, nil
+}
+
+/**
+ * Obtain the path to a command from outside the chroot it runs in, if any
+ */
+func hostPath(c string) string {
+  if conf.Chroot == "" {
+    return c
+  }
+  return filepath.Join(conf.Chroot, c)
+}
+
 /**
  * Get the currently-running process
  */
@@ -152,60 +475,291 @@ func process() *os.Process {
 }
 
 /**
- * Set the currently-running process
+ * Set the currently-running process. The child PID file, if any, follows the
+ * process from one generation to the next.
  */
 func setProcess(p *os.Process) {
   lock.Lock()
   defer lock.Unlock()
   proc = p
+  if p != nil {
+    noteStarted()
+  }
+  if p != nil && conf.ChildPidfile != "" {
+    err := writePidfile(conf.ChildPidfile, p.Pid, true)
+    if err != nil {
+      logf("Could not update child PID file: %v", err)
+    }
+  }else if conf.ChildPidfile != "" {
+    err := os.Remove(conf.ChildPidfile) // there's no child, so don't name a dead one
+    if err != nil && !os.IsNotExist(err) {
+      logf("Could not remove child PID file: %v", err)
+    }
+  }
+  arm(p)
+}
+
+/**
+ * Arm the reload action for a process. The caller must hold the lock.
+ */
+func arm(p *os.Process) {
   if group != nil {
-    group.Invoke()
+    group.Cancel()
   }
   group = newGrouper(time.Millisecond * 2500, func() error {
-    return term(p)
+    return reload(p)
   })
 }
 
+/**
+ * Build before the first generation is started. Changes made during the build
+ * start a new one, which cancels the stale one, and after a failed build we
+ * wait for changes before trying again.
+ */
+func startupBuild() {
+  var built chan error
+  build := func() {
+    c := make(chan error, 1) // superseded builds finish on their own
+    go func() {
+      c <- builds.Build()
+    }()
+    built = c
+  }
+  
+  build()
+  for {
+    select {
+      case err := <- built:
+        if err == nil {
+          return
+        }
+        if err == errSuperseded {
+          build()
+        }else{
+          logf("Build failed, waiting for changes: %v", err)
+          built = nil
+        }
+      case <- changes:
+        build()
+    }
+  }
+}
+
+/**
+ * Reload a process. With a build step the current generation keeps running
+ * until a build succeeds, so a failed build leaves it in place. Changes made
+ * during a build start a new one, which cancels the stale one.
+ */
+func reload(p *os.Process) error {
+  if builds == nil {
+    return term(p)
+  }
+  go func() {
+    lock.Lock()
+    if proc == p {
+      arm(p) // further changes should start another build
+    }
+    lock.Unlock()
+    
+    err := builds.Build()
+    if err == errSuperseded {
+      return
+    }else if err != nil {
+      logf("Build failed, keeping the current generation: %v", err)
+      return
+    }
+    term(process())
+  }()
+  return nil
+}
+
 /**
  * Run a process.
  */
 func run(c string, a []string) {
-  fmt.Printf("%v: %v %v\n", conf.Cmd, c, strings.Join(a, " "))
+  select {
+    case <- changes: // clear anything that led up to this run
+    default:
+  }
+  
+  if conf.Build != "" {
+    var err error
+    c, err = locate(c)
+    if err != nil {
+      logf("Could not find command, waiting for changes: %v", err)
+      <- changes
+      return
+    }
+  }
+  
+  if conf.Verify != nil {
+    err := hotswap.Verify(hostPath(c), *conf.Verify)
+    if err != nil {
+      logf("Not starting unverified command, retrying in %v: %v", verifyRetry, err)
+      select { // the command may be replaced outside of anything we watch
+        case <- changes:
+        case <- time.After(verifyRetry):
+      }
+      return
+    }
+  }
+  
+  env, err := environment()
+  if err != nil {
+    logf("Could not load environment, waiting for changes: %v", err)
+    <- changes
+    return
+  }
+  
+  logf("%v %v", c, strings.Join(a, " "))
   
   cmd := exec.Command(c, a...)
-  cmd.Env = append(os.Environ(), fmt.Sprintf("GO_HOTSWAP_MANAGER_PID=%d", os.Getpid()), fmt.Sprintf("GO_HOTSWAP_GENERATION=%d", generation))
-  cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+  cmd.Env = append(env, fmt.Sprintf("GO_HOTSWAP_MANAGER_PID=%d", os.Getpid()), fmt.Sprintf("GO_HOTSWAP_GENERATION=%d", generation))
+  if beats != nil {
+    cmd.Env = append(cmd.Env, fmt.Sprintf("GO_HOTSWAP_HEARTBEAT=%s", beats.addr))
+  }
+  cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: conf.Credential, Chroot: conf.Chroot}
+  cmd.Dir = conf.Dir
+  if len(conf.Rlimits) > 0 || conf.Umask != nil {
+    err := prepare(cmd, execSpec{Path:c, Rlimits:conf.Rlimits, Umask:conf.Umask})
+    if err != nil {
+      panic(err)
+    }
+  }
+  
+  name := path.Base(c)
+  pout := newLineWriter(os.Stdout, name, generation)
+  perr := newLineWriter(os.Stderr, name, generation)
+  cmd.Stdout = io.MultiWriter(pout, tap)
+  cmd.Stderr = io.MultiWriter(perr, tap)
+  if logs != nil {
+    err := logs.Rotate() // each generation starts a new file
+    if err != nil {
+      logf("Could not start log file: %v", err)
+    }
+    cmd.Stdout = io.MultiWriter(cmd.Stdout, logs)
+    cmd.Stderr = io.MultiWriter(cmd.Stderr, logs)
+  }
+  var ring *lineRing
+  if conf.CrashLines > 0 {
+    ring = newLineRing(conf.CrashLines)
+    cmd.Stdout = io.MultiWriter(cmd.Stdout, ring)
+    cmd.Stderr = io.MultiWriter(cmd.Stderr, ring)
+  }
+  cmd.WaitDelay = time.Second // in case descendants hold on to our pipes
   generation++
   
-  pout, err := cmd.StdoutPipe()
-  if err != nil {
-    panic(err)
+  var tty *ptySession
+  if conf.TTY {
+    var err error
+    tty, err = attachPty(cmd, cmd.Stdout) // a terminal has just the one output
+    if err != nil {
+      panic(err)
+    }
   }
   
-  perr, err := cmd.StderrPipe()
-  if err != nil {
-    panic(err)
+  var cg *cgroup
+  if conf.Cgroup != "" {
+    var err error
+    cg, err = newCgroup(conf.Cgroup, fmt.Sprintf("hotswap-%d-%d", os.Getpid(), generation - 1))
+    if err != nil {
+      panic(err)
+    }
+    cg.Attach(cmd)
+    lock.Lock()
+    pgroup = cg // so it can be cleaned up if we're killed
+    lock.Unlock()
   }
   
   err = cmd.Start()
   if err != nil {
     panic(err)
   }
+  if tty != nil {
+    tty.Started()
+  }
   
   setProcess(cmd.Process)
   defer setProcess(nil)
   
+  if conf.Usage.MaxRSS > 0 || conf.Usage.WarnCPU > 0 {
+    defer watchUsage(cmd.Process, conf.Usage)()
+  }
+  if len(conf.Probes) > 0 {
+    defer watchProbes(cmd.Process, name, conf.Probes, conf.ProbeGrace)()
+  }
+  
   fmt.Println()
-  go io.Copy(os.Stdout, pout)
-  go io.Copy(os.Stdout, perr)
   
   err = cmd.Wait()
+  if cg != nil && takeCgroup() == cg {
+    reap(cg)
+  }
+  if tty != nil {
+    tty.Finish()
+  }
+  pout.Flush()
+  perr.Flush()
   if err != nil {
-    fmt.Printf("%v: Process exited with error: %v\n", conf.Cmd, err)
+    logf("Process exited with error: %v", err)
+    if ring != nil && !expectedExit(cmd.Process) {
+      report(crash{Name:name, Command:strings.TrimSpace(c +" "+ strings.Join(a, " ")), Generation:generation - 1, Pid:cmd.Process.Pid, Err:err, Env:cmd.Env, PrevEnv:prevEnv, Output:ring.Lines()})
+    }
   }
+  prevEnv = cmd.Env
   
 }
 
+/**
+ * Write a crash report and tell the user where to find it
+ */
+func report(c crash) {
+  status.Lock()
+  c.Started = status.started
+  c.Changes = status.changes
+  status.Unlock()
+  c.Exited = time.Now()
+  
+  p, err := c.Write(conf.CrashDir)
+  if err != nil {
+    logf("Could not write crash report: %v", err)
+    return
+  }
+  logf("Process crashed after %v (%v), report saved to: %v", c.Exited.Sub(c.Started).Round(time.Millisecond), exitStatus(c.Err), p)
+}
+
+/**
+ * Take responsibility for cleaning up the current generation's cgroup, if
+ * nobody else has already
+ */
+func takeCgroup() *cgroup {
+  lock.Lock()
+  defer lock.Unlock()
+  cg := pgroup
+  pgroup = nil
+  return cg
+}
+
+/**
+ * Kill whatever is left in a generation's cgroup. We don't go on to start
+ * another generation until it's empty, since survivors may be holding on to
+ * resources it needs, backing off while we wait.
+ */
+func reap(cg *cgroup) {
+  delay := time.Second
+  for {
+    err := cg.Destroy(time.Second * 5)
+    if err == nil {
+      return
+    }
+    logf("Could not clean up after the last generation, retrying in %v: %v", delay, err)
+    <- time.After(delay)
+    if delay < time.Minute {
+      delay *= 2
+    }
+  }
+}
+
 /**
  * Mark a reload event
  */
@@ -215,24 +769,45 @@ func event() {
   if group != nil {
     group.Event()
   }
+  select {
+    case changes <- struct{}{}:
+    default:
+  }
 }
 
 /**
  * Kill the currently running process, allowing it to restart.
  */
 func term(p *os.Process) error {
-  fmt.Printf("%v: Reloading process [%v]...\n", conf.Cmd, conf.Signal)
+  logf("Reloading process [%v]...", conf.Signal)
   if p != nil {
-    pgid, err := syscall.Getpgid(p.Pid)
+    expectExit(p)
+    err := signalProcess(p, syscall.SIGTERM)
     if err != nil {
       panic(err)
-    }else{
-      syscall.Kill(-pgid, 15) // note the minus sign
     }
   }
   return nil
 }
 
+/**
+ * Send a signal to a process and everything in its group. A process which is
+ * already gone is not an error.
+ */
+func signalProcess(p *os.Process, sig syscall.Signal) error {
+  pgid, err := syscall.Getpgid(p.Pid)
+  if err == syscall.ESRCH {
+    return nil
+  }else if err != nil {
+    return err
+  }
+  err = syscall.Kill(-pgid, sig) // note the minus sign
+  if err == syscall.ESRCH {
+    return nil
+  }
+  return err
+}
+
 /**
  * Monitor for restart
  */
@@ -269,7 +844,15 @@ func monitor(d, f []string) {
     switch e.Op {
       case fsnotify.Write, fsnotify.Remove, fsnotify.Rename:
         if conf.Verbose { fmt.Printf("--> %v %v\n", time.Now(), e) }
-        event()
+        if paused() {
+          continue
+        }
+        noteChange(e.Name)
+        if r := ruleFor(rules, e.Name); r != nil {
+          r.Event()
+        }else{
+          event()
+        }
     }
   }
   
@@ -308,11 +891,10 @@ func monitorPath(w *fsnotify.Watcher, d string, f []string) error {
     
   }else{
     
-    if len(f) > 0 {
+    if len(f) > 0 && ruleFor(rules, d) == nil {
       match := false
-      fname := finfo.Name()
       for _, x := range f {
-        m, err := path.Match(x, fname)
+        m, err := matchPattern(x, d)
         if err != nil {
           return err
         }
@@ -336,22 +918,111 @@ func monitorPath(w *fsnotify.Watcher, d string, f []string) error {
 }
 
 /**
- * Handle signals
+ * Handle signals. SIGINT and SIGTERM shut down gracefully; a second one kills
+ * the managed process outright. SIGHUP restarts the managed process, and
+ * SIGUSR1, SIGUSR2, SIGQUIT and SIGWINCH are passed on to it.
  */
 func signals() {
   sig := make(chan os.Signal, 1)
-  signal.Notify(sig, os.Kill)
-  signal.Notify(sig, os.Interrupt)
+  signal.Notify(sig, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGQUIT, syscall.SIGWINCH)
   go func() {
-    for range sig {
-      if conf.DumpOnExit {
-        fmt.Printf("\n%v: Received a signal, dumping stack...\n", conf.Cmd)
-        data := make([]byte, 5 << 20)
-        n := runtime.Stack(data, true)
-        io.Copy(os.Stderr, bytes.NewReader(data[:n]))
+    quitting := false
+    for v := range sig {
+      switch v {
+        case os.Interrupt, syscall.SIGTERM:
+          if quitting {
+            logf("Received a second signal, killing process...")
+            kill()
+          }
+          quitting = true
+          if conf.DumpOnExit {
+            fmt.Println()
+            logf("Received a signal, dumping stack...")
+            data := make([]byte, 5 << 20)
+            n := runtime.Stack(data, true)
+            io.Copy(os.Stderr, bytes.NewReader(data[:n]))
+          }
+          go shutdown()
+        case syscall.SIGHUP:
+          logf("Received %v, restarting...", v)
+          term(process())
+        case syscall.SIGWINCH:
+          if conf.TTY {
+            break // resizing the terminal takes care of this
+          }
+          fallthrough
+        default:
+          if p := process(); p != nil {
+            err := signalProcess(p, v.(syscall.Signal))
+            if err != nil {
+              logf("Could not forward %v: %v", v, err)
+            }
+          }
       }
-      term(process())
-      os.Exit(0)
     }
   }()
 }
+
+/**
+ * Stop the managed process gracefully, clean up after ourselves and exit. If
+ * the process doesn't exit in time it's killed.
+ */
+func shutdown() {
+  status.Lock()
+  if status.resume == nil {
+    status.resume = make(chan struct{}) // don't start another generation
+  }
+  status.Unlock()
+  
+  if p := process(); p != nil {
+    logf("Stopping process...")
+    expectExit(p)
+    signalProcess(p, syscall.SIGTERM)
+    deadline := time.Now().Add(conf.StopTimeout)
+    for process() == p {
+      if time.Now().After(deadline) {
+        logf("Process did not exit within %v, killing it...", conf.StopTimeout)
+        kill()
+      }
+      <- time.After(time.Millisecond * 50)
+    }
+  }
+  
+  cleanup()
+  os.Exit(0)
+}
+
+/**
+ * Kill the managed process immediately and exit
+ */
+func kill() {
+  if p := process(); p != nil {
+    expectExit(p)
+    signalProcess(p, syscall.SIGKILL)
+  }
+  cleanup()
+  os.Exit(1)
+}
+
+/**
+ * Clean up after ourselves before exiting
+ */
+func cleanup() {
+  if cg := takeCgroup(); cg != nil {
+    err := cg.Destroy(time.Second * 5)
+    if err != nil {
+      logf("Could not clean up after the last generation: %v", err)
+    }
+  }
+  restoreTerminal()
+  removePidfiles()
+  if conf.Control != "" {
+    os.Remove(conf.Control)
+  }
+  if beats != nil {
+    os.Remove(beats.path)
+  }
+  if logs != nil {
+    logs.Wait() // don't leave compression half done
+  }
+}
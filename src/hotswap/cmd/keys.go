@@ -0,0 +1,186 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "sort"
+  "sync"
+  "time"
+)
+
+// in pseudo-terminal mode, keys are prefixed with Ctrl-T
+const keyEscape = 0x14
+
+// whether the last key read was the escape
+var escaped bool
+
+/**
+ * What we report when asked for our status
+ */
+var status struct {
+  sync.Mutex
+  paused  bool
+  resume  chan struct{}
+  started time.Time
+  changed time.Time
+  file    string
+  pending map[string]struct{} // files changed since the current generation started
+  changes []string            // files changed before the current generation started
+}
+
+/**
+ * Determine if watching is paused
+ */
+func paused() bool {
+  status.Lock()
+  defer status.Unlock()
+  return status.paused
+}
+
+/**
+ * Note that a file changed
+ */
+func noteChange(f string) {
+  status.Lock()
+  defer status.Unlock()
+  status.changed = time.Now()
+  status.file = f
+  if status.pending == nil {
+    status.pending = make(map[string]struct{})
+  }
+  status.pending[f] = struct{}{}
+}
+
+/**
+ * Note that a generation started
+ */
+func noteStarted() {
+  status.Lock()
+  defer status.Unlock()
+  status.started = time.Now()
+  status.changes = nil
+  for k := range status.pending {
+    status.changes = append(status.changes, k)
+  }
+  sort.Strings(status.changes)
+  status.pending = nil
+}
+
+/**
+ * Handle keyboard input, returning whatever should be forwarded to the
+ * managed process. Without a pseudo-terminal every key is a command; with one,
+ * commands are prefixed by Ctrl-T and everything else is passed through. Press
+ * Ctrl-T twice to send it to the process.
+ */
+func filterKeys(p []byte) []byte {
+  if !conf.TTY {
+    for _, b := range p {
+      key(b)
+    }
+    return nil
+  }
+  
+  var out []byte
+  for _, b := range p {
+    switch {
+      case escaped:
+        escaped = false
+        if b == keyEscape {
+          out = append(out, b)
+        }else{
+          key(b)
+        }
+      case b == keyEscape:
+        escaped = true
+      default:
+        out = append(out, b)
+    }
+  }
+  return out
+}
+
+/**
+ * Carry out a keyboard command
+ */
+func key(b byte) {
+  switch b {
+    case 'r':
+      logf("Restarting...")
+      term(process())
+    case 'b':
+      if builds == nil {
+        logf("No build step is configured")
+      }else{
+        reload(process())
+      }
+    case 'p':
+      setPaused(!paused())
+    case 'c':
+      output.Lock()
+      os.Stdout.WriteString("\x1b[H\x1b[2J")
+      output.Unlock()
+    case 'q':
+      logf("Quitting...")
+      shutdown()
+    case '?':
+      printStatus()
+    case '\r', '\n':
+      // ignore
+    default:
+      logf("Keys: [r]estart, [b]uild, [p]ause/resume watching, [c]lear, [q]uit, [?] status")
+  }
+}
+
+/**
+ * Pause or resume watching
+ */
+func setPaused(v bool) error {
+  status.Lock()
+  status.paused = v
+  status.Unlock()
+  if v {
+    logf("Watching paused")
+  }else{
+    logf("Watching resumed")
+  }
+  return nil
+}
+
+/**
+ * Print our status
+ */
+func printStatus() {
+  for _, e := range statusLines() {
+    logf("%v", e)
+  }
+}
+
+/**
+ * Describe our status
+ */
+func statusLines() []string {
+  var pid string
+  if p := process(); p != nil {
+    pid = fmt.Sprintf("%d", p.Pid)
+  }else{
+    pid = "not running"
+  }
+  
+  status.Lock()
+  defer status.Unlock()
+  
+  s := []string{fmt.Sprintf("Generation %d, PID %v", generation - 1, pid)}
+  if !status.started.IsZero() && pid != "not running" {
+    s = append(s, fmt.Sprintf("  Uptime: %v", time.Since(status.started).Round(time.Second)))
+  }
+  if status.file != "" {
+    s = append(s, fmt.Sprintf("  Last change: %v, %v ago", status.file, time.Since(status.changed).Round(time.Second)))
+  }
+  if status.resume != nil {
+    s = append(s, "  The process is stopped")
+  }
+  if status.paused {
+    s = append(s, "  Watching is paused")
+  }
+  return s
+}
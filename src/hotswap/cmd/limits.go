@@ -0,0 +1,232 @@
+package main
+
+import (
+  "os"
+  "os/exec"
+  "fmt"
+  "strings"
+  "strconv"
+  "syscall"
+  "encoding/json"
+)
+
+// set when hotswap is re-executed to prepare the managed process
+const envExec = "GO_HOTSWAP_EXEC"
+
+/**
+ * Resource limits which may be named on the command line
+ */
+var rlimitNames = map[string]int{
+  "as":     syscall.RLIMIT_AS,
+  "core":   syscall.RLIMIT_CORE,
+  "cpu":    syscall.RLIMIT_CPU,
+  "data":   syscall.RLIMIT_DATA,
+  "fsize":  syscall.RLIMIT_FSIZE,
+  "nofile": syscall.RLIMIT_NOFILE,
+  "stack":  syscall.RLIMIT_STACK,
+}
+
+/**
+ * A resource limit
+ */
+type rlimit struct {
+  Name      string  `json:"name"`
+  Resource  int     `json:"resource"`
+  Soft      uint64  `json:"soft"`
+  Hard      uint64  `json:"hard"`
+}
+
+/**
+ * What must be done in the managed process before the command is executed
+ */
+type execSpec struct {
+  Path        string              `json:"path"`
+  Rlimits     []rlimit            `json:"rlimits,omitempty"`
+  Umask       *int                `json:"umask,omitempty"`
+  Chroot      string              `json:"chroot,omitempty"`
+  Dir         string              `json:"dir,omitempty"`
+  Credential  *syscall.Credential `json:"credential,omitempty"`
+}
+
+/**
+ * Parse a resource limit in the form '<name>=<soft>[:<hard>]'. Limits may use
+ * size suffixes (K, M, G) or be 'unlimited'; if no hard limit is given, it's
+ * the same as the soft limit.
+ */
+func parseRlimit(s string) (rlimit, error) {
+  x := strings.Index(s, "=")
+  if x < 1 {
+    return rlimit{}, fmt.Errorf("Invalid resource limit, expected '<name>=<soft>[:<hard>]': %v", s)
+  }
+  
+  name := strings.ToLower(s[:x])
+  r, ok := rlimitNames[name]
+  if !ok {
+    return rlimit{}, fmt.Errorf("Unknown resource: %v", s[:x])
+  }
+  
+  v, h := s[x+1:], ""
+  if x = strings.Index(v, ":"); x >= 0 {
+    v, h = v[:x], v[x+1:]
+  }
+  
+  soft, err := parseLimit(v)
+  if err != nil {
+    return rlimit{}, err
+  }
+  hard := soft
+  if h != "" {
+    hard, err = parseLimit(h)
+    if err != nil {
+      return rlimit{}, err
+    }
+  }
+  if soft > hard {
+    return rlimit{}, fmt.Errorf("Soft limit exceeds hard limit: %v", s)
+  }
+  
+  return rlimit{name, r, soft, hard}, nil
+}
+
+/**
+ * Parse a limit value
+ */
+func parseLimit(s string) (uint64, error) {
+  if strings.EqualFold(s, "unlimited") {
+    return ^uint64(0), nil // RLIM_INFINITY
+  }
+  n, err := parseSize(s)
+  if err != nil {
+    return 0, err
+  }
+  return uint64(n), nil
+}
+
+/**
+ * Parse a size with an optional suffix: K, M or G
+ */
+func parseSize(s string) (int64, error) {
+  m := int64(1)
+  if n := len(s); n > 0 {
+    switch s[n-1] {
+      case 'k', 'K':
+        m = 1 << 10
+      case 'm', 'M':
+        m = 1 << 20
+      case 'g', 'G':
+        m = 1 << 30
+    }
+    if m > 1 {
+      s = s[:n-1]
+    }
+  }
+  n, err := strconv.ParseInt(s, 10, 64)
+  if err != nil || n < 0 {
+    return 0, fmt.Errorf("Invalid size: %v", s)
+  }
+  return n * m, nil
+}
+
+/**
+ * Arrange for a command to be started through hotswap itself, so that limits
+ * can be applied between fork and exec. Since hotswap may not be reachable
+ * from inside a chroot or executable by another user, the chroot, working
+ * directory and credentials are then applied by hotswap too.
+ */
+func prepare(cmd *exec.Cmd, spec execSpec) error {
+  self := "/proc/self/exe"
+  if _, err := os.Stat(self); err != nil {
+    self, err = os.Executable()
+    if err != nil {
+      return err
+    }
+  }
+  
+  spec.Chroot, cmd.SysProcAttr.Chroot = cmd.SysProcAttr.Chroot, ""
+  spec.Credential, cmd.SysProcAttr.Credential = cmd.SysProcAttr.Credential, nil
+  spec.Dir, cmd.Dir = cmd.Dir, ""
+  
+  data, err := json.Marshal(spec)
+  if err != nil {
+    return err
+  }
+  
+  cmd.Path = self
+  cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", envExec, data))
+  return nil
+}
+
+/**
+ * Prepare the managed process and execute the command. This only returns if
+ * something went wrong, with the status the process should exit with.
+ */
+func trampoline(v string) int {
+  var spec execSpec
+  
+  err := json.Unmarshal([]byte(v), &spec)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "hotswap: Invalid exec state: %v\n", err)
+    return 127
+  }
+  
+  for _, e := range spec.Rlimits {
+    err = syscall.Setrlimit(e.Resource, &syscall.Rlimit{Cur:e.Soft, Max:e.Hard})
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "hotswap: Could not set %v limit: %v\n", e.Name, err)
+      return 127
+    }
+  }
+  
+  if spec.Umask != nil {
+    syscall.Umask(*spec.Umask)
+  }
+  
+  if spec.Chroot != "" {
+    err = syscall.Chroot(spec.Chroot)
+    if err == nil && spec.Dir == "" {
+      err = os.Chdir("/")
+    }
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "hotswap: Could not change root: %v\n", err)
+      return 127
+    }
+  }
+  if spec.Dir != "" {
+    err = os.Chdir(spec.Dir)
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "hotswap: %v\n", err)
+      return 127
+    }
+  }
+  
+  if c := spec.Credential; c != nil {
+    if !c.NoSetGroups {
+      groups := make([]int, len(c.Groups))
+      for i, e := range c.Groups {
+        groups[i] = int(e)
+      }
+      err = syscall.Setgroups(groups)
+    }
+    if err == nil {
+      err = syscall.Setgid(int(c.Gid))
+    }
+    if err == nil {
+      err = syscall.Setuid(int(c.Uid))
+    }
+    if err != nil {
+      fmt.Fprintf(os.Stderr, "hotswap: Could not change credentials: %v\n", err)
+      return 127
+    }
+  }
+  
+  var env []string
+  for _, e := range os.Environ() {
+    if !strings.HasPrefix(e, envExec +"=") {
+      env = append(env, e)
+    }
+  }
+  
+  err = syscall.Exec(spec.Path, os.Args, env)
+  fmt.Fprintf(os.Stderr, "hotswap: %v\n", err)
+  return 127
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+  "testing"
+  "syscall"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestParseRlimit(t *testing.T) {
+  
+  r, err := parseRlimit("nofile=4096")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, syscall.RLIMIT_NOFILE, r.Resource)
+  assert.Equal(t, uint64(4096), r.Soft)
+  assert.Equal(t, uint64(4096), r.Hard)
+  
+  r, err = parseRlimit("AS=1G:2G")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, "as", r.Name)
+  assert.Equal(t, uint64(1 << 30), r.Soft)
+  assert.Equal(t, uint64(2 << 30), r.Hard)
+  
+  r, err = parseRlimit("core=0:unlimited")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, uint64(0), r.Soft)
+  assert.Equal(t, ^uint64(0), r.Hard)
+  
+  for _, e := range []string{"nofile", "=10", "bogus=10", "nofile=lots", "nofile=-1", "nofile=20:10"} {
+    _, err = parseRlimit(e)
+    assert.NotNil(t, err, e)
+  }
+  
+}
+
+func TestParseSize(t *testing.T) {
+  tests := []struct{
+    size  string
+    bytes int64
+  }{
+    {"100", 100},
+    {"4k", 4 << 10},
+    {"512M", 512 << 20},
+    {"2G", 2 << 30},
+  }
+  for _, e := range tests {
+    n, err := parseSize(e.size)
+    if assert.Nil(t, err, e.size) {
+      assert.Equal(t, e.bytes, n, e.size)
+    }
+  }
+  _, err := parseSize("M")
+  assert.NotNil(t, err)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "sort"
+  "sync"
+  "time"
+  "regexp"
+  "path/filepath"
+  "compress/gzip"
+)
+
+/**
+ * A log file for a managed process. Output is written to a timestamped file
+ * which is rotated on each generation and whenever it grows too large or old;
+ * the 'current' symlink always refers to the file being written.
+ */
+type logFile struct {
+  sync.Mutex
+  dir       string
+  name      string
+  maxSize   int64
+  maxAge    time.Duration
+  keep      int
+  compress  bool
+  file      *os.File
+  path      string
+  size      int64
+  opened    time.Time
+  failed    bool
+  archiver  sync.Mutex      // one file is archived at a time, so pruning never races compression
+  archiving sync.WaitGroup
+}
+
+// the timestamp in log file names
+const logTime = "20060102T150405.000000000"
+
+// matches log file names, given the quoted name of the process
+const logPattern = `^%s-\d{8}T\d{6}\.\d{9}\.log(\.gz)?$`
+
+/**
+ * Create a log file in the specified directory. If we create the directory,
+ * it belongs to the user the managed process runs as; an existing one is
+ * left alone.
+ */
+func newLogFile(dir, name string) (*logFile, error) {
+  _, err := os.Stat(dir)
+  created := os.IsNotExist(err)
+  err = os.MkdirAll(dir, 0755)
+  if err == nil && created {
+    err = chown(dir)
+  }
+  if err != nil {
+    return nil, err
+  }
+  return &logFile{dir:dir, name:name}, nil
+}
+
+/**
+ * Write output. Failures are reported once rather than returned, so a full
+ * disk doesn't also cost us the output on the terminal.
+ */
+func (l *logFile) Write(p []byte) (int, error) {
+  l.Lock()
+  defer l.Unlock()
+  
+  var err error
+  if l.file == nil || (l.maxSize > 0 && l.size + int64(len(p)) > l.maxSize) || (l.maxAge > 0 && time.Since(l.opened) > l.maxAge) {
+    err = l.rotate()
+  }
+  if err == nil {
+    var n int
+    n, err = l.file.Write(p)
+    l.size += int64(n)
+  }
+  
+  if err != nil && !l.failed {
+    logf("Could not write log file: %v", err)
+  }
+  l.failed = err != nil
+  return len(p), nil
+}
+
+/**
+ * Start a new file
+ */
+func (l *logFile) Rotate() error {
+  l.Lock()
+  defer l.Unlock()
+  return l.rotate()
+}
+
+/**
+ * Start a new file. The caller must hold the lock.
+ */
+func (l *logFile) rotate() error {
+  prev := l.path
+  if l.file != nil {
+    l.file.Close()
+    l.file = nil
+  }
+  
+  now := time.Now()
+  l.path = filepath.Join(l.dir, fmt.Sprintf("%v-%v.log", l.name, now.Format(logTime)))
+  f, err := createFile(l.path, 0644)
+  if err == nil {
+    err = chown(l.path)
+  }
+  if err != nil {
+    return err
+  }
+  l.file, l.size, l.opened = f, 0, now
+  
+  // replace the symlink atomically
+  tmp := filepath.Join(l.dir, "current.tmp")
+  os.Remove(tmp)
+  err = os.Symlink(filepath.Base(l.path), tmp)
+  if err == nil {
+    err = chown(tmp)
+  }
+  if err == nil {
+    err = os.Rename(tmp, filepath.Join(l.dir, "current"))
+  }
+  if err != nil {
+    return err
+  }
+  
+  if prev != "" {
+    l.archiving.Add(1)
+    go func() {
+      defer l.archiving.Done()
+      l.archive(prev)
+    }()
+  }
+  return nil
+}
+
+/**
+ * Wait for files we're done with to be archived
+ */
+func (l *logFile) Wait() {
+  l.archiving.Wait()
+}
+
+/**
+ * Compress a file we're done with, if configured, and prune old files
+ */
+func (l *logFile) archive(p string) {
+  l.archiver.Lock()
+  defer l.archiver.Unlock()
+  
+  if l.compress {
+    err := compressFile(p)
+    if err != nil && !os.IsNotExist(err) { // it may have been pruned already
+      logf("Could not compress log file: %v", err)
+    }
+  }
+  
+  if l.keep > 0 {
+    l.prune()
+  }
+}
+
+/**
+ * Remove the oldest files beyond the number we keep. Only files named for our
+ * process with a timestamp are considered, so those of another process with a
+ * longer name starting with ours are left alone.
+ */
+func (l *logFile) prune() {
+  l.Lock()
+  defer l.Unlock()
+  
+  d, err := os.ReadDir(l.dir)
+  if err != nil {
+    logf("Could not prune log files: %v", err)
+    return
+  }
+  
+  r := regexp.MustCompile(fmt.Sprintf(logPattern, regexp.QuoteMeta(l.name)))
+  var m []string
+  for _, e := range d {
+    if e.Type().IsRegular() && r.MatchString(e.Name()) {
+      m = append(m, filepath.Join(l.dir, e.Name()))
+    }
+  }
+  sort.Strings(m) // oldest first, by virtue of the timestamp
+  for len(m) > l.keep {
+    if m[0] != l.path {
+      os.Remove(m[0])
+    }
+    m = m[1:]
+  }
+}
+
+/**
+ * Gzip a file in place
+ */
+func compressFile(p string) error {
+  src, err := os.Open(p)
+  if err != nil {
+    return err
+  }
+  defer src.Close()
+  
+  tmp := p +".gz.tmp"
+  os.Remove(tmp) // left over from a failed attempt
+  dst, err := createFile(tmp, 0644)
+  if err != nil {
+    return err
+  }
+  chown(tmp) // keep the owner of the original
+  
+  z := gzip.NewWriter(dst)
+  _, err = io.Copy(z, src)
+  if err == nil {
+    err = z.Close()
+  }
+  if cerr := dst.Close(); err == nil {
+    err = cerr
+  }
+  if err == nil {
+    err = os.Rename(tmp, p +".gz")
+  }
+  if err != nil {
+    os.Remove(tmp)
+    return err
+  }
+  
+  return os.Remove(p)
+}
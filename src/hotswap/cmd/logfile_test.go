@@ -0,0 +1,128 @@
+package main
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "sort"
+  "time"
+  "regexp"
+  "testing"
+  "path/filepath"
+  "compress/gzip"
+  "github.com/stretchr/testify/assert"
+)
+
+/**
+ * List the log files in a directory, oldest first
+ */
+func testLogFiles(t *testing.T, dir string) []string {
+  d, err := os.ReadDir(dir)
+  assert.Nil(t, err)
+  r := regexp.MustCompile(fmt.Sprintf(logPattern, "app"))
+  var m []string
+  for _, e := range d {
+    if r.MatchString(e.Name()) {
+      m = append(m, filepath.Join(dir, e.Name()))
+    }
+  }
+  sort.Strings(m)
+  return m
+}
+
+/**
+ * Read a log file, compressed or not
+ */
+func testReadLog(t *testing.T, p string) string {
+  f, err := os.Open(p)
+  if !assert.Nil(t, err) { return "" }
+  defer f.Close()
+  
+  var r io.Reader = f
+  if filepath.Ext(p) == ".gz" {
+    z, err := gzip.NewReader(f)
+    if !assert.Nil(t, err) { return "" }
+    r = z
+  }
+  b, err := io.ReadAll(r)
+  assert.Nil(t, err)
+  return string(b)
+}
+
+func TestLogFileRotate(t *testing.T) {
+  dir := filepath.Join(t.TempDir(), "logs")
+  l, err := newLogFile(dir, "app")
+  if !assert.Nil(t, err) { return }
+  l.maxSize = 10
+  
+  // nothing is created until there's output
+  l.Write([]byte("one\n"))
+  l.Write([]byte("two\n"))
+  m := testLogFiles(t, dir)
+  if !assert.Len(t, m, 1) { return }
+  
+  // the current file is always linked
+  link, err := os.Readlink(filepath.Join(dir, "current"))
+  assert.Nil(t, err)
+  assert.Equal(t, filepath.Base(m[0]), link)
+  
+  // too large
+  l.Write([]byte("three\n"))
+  m = testLogFiles(t, dir)
+  if !assert.Len(t, m, 2) { return }
+  assert.Equal(t, "one\ntwo\n", testReadLog(t, m[0]))
+  assert.Equal(t, "three\n", testReadLog(t, filepath.Join(dir, "current")))
+  
+  // each generation starts a new file
+  err = l.Rotate()
+  assert.Nil(t, err)
+  l.Write([]byte("four\n"))
+  m = testLogFiles(t, dir)
+  if !assert.Len(t, m, 3) { return }
+  assert.Equal(t, "four\n", testReadLog(t, m[2]))
+  
+  // too old
+  l.maxSize = 0
+  l.maxAge = time.Millisecond * 10
+  <- time.After(time.Millisecond * 20)
+  l.Write([]byte("five\n"))
+  m = testLogFiles(t, dir)
+  if !assert.Len(t, m, 4) { return }
+  assert.Equal(t, "five\n", testReadLog(t, m[3]))
+  
+  l.Wait()
+}
+
+func TestLogFileArchive(t *testing.T) {
+  dir := t.TempDir()
+  l, err := newLogFile(dir, "app")
+  if !assert.Nil(t, err) { return }
+  l.keep = 2
+  l.compress = true
+  
+  // files of other processes and anything else is left alone
+  others := []string{"app-worker-20200101T000000.000000000.log", "app-notes.log", "app-20200101T000000.000000000.log.gz.tmp"}
+  for _, e := range others {
+    err = os.WriteFile(filepath.Join(dir, e), []byte("other\n"), 0644)
+    if !assert.Nil(t, err) { return }
+  }
+  
+  for _, e := range []string{"one\n", "two\n", "three\n", "four\n"} {
+    err = l.Rotate()
+    if !assert.Nil(t, err) { return }
+    l.Write([]byte(e))
+  }
+  l.Wait()
+  
+  m := testLogFiles(t, dir)
+  if !assert.Len(t, m, 2) { return }
+  assert.Equal(t, ".gz", filepath.Ext(m[0]))
+  assert.Equal(t, "three\n", testReadLog(t, m[0]))
+  assert.Equal(t, ".log", filepath.Ext(m[1]))
+  assert.Equal(t, "four\n", testReadLog(t, m[1]))
+  assert.Equal(t, "four\n", testReadLog(t, filepath.Join(dir, "current")))
+  
+  for _, e := range others {
+    assert.Equal(t, "other\n", testReadLog(t, filepath.Join(dir, e)), e)
+  }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "sync"
+  "time"
+  "bytes"
+)
+
+const (
+  colorReset  = "\x1b[0m"
+  colorInfo   = "\x1b[1;36m"
+  colorPrefix = "\x1b[2m"
+)
+
+// serializes everything written to the terminal so lines don't interleave
+var output sync.Mutex
+
+/**
+ * Determine if a file is a terminal
+ */
+func isTerminal(f *os.File) bool {
+  finfo, err := f.Stat()
+  if err != nil {
+    return false
+  }
+  return finfo.Mode() & os.ModeCharDevice != 0
+}
+
+/**
+ * Print one of our own messages
+ */
+func logf(f string, a ...interface{}) {
+  var b bytes.Buffer
+  if conf.Timestamps {
+    b.WriteString(time.Now().Format("15:04:05.000 "))
+  }
+  if conf.Color {
+    b.WriteString(colorInfo)
+  }
+  fmt.Fprintf(&b, "%v: ", conf.Cmd)
+  fmt.Fprintf(&b, f, a...)
+  if conf.Color {
+    b.WriteString(colorReset)
+  }
+  b.WriteByte('\n')
+  
+  output.Lock()
+  defer output.Unlock()
+  os.Stdout.Write(b.Bytes())
+}
+
+/**
+ * Writes output from a managed process. When prefixes or timestamps are
+ * enabled output is buffered by line, and each line is tagged; otherwise it's
+ * passed through as it's written.
+ */
+type lineWriter struct {
+  dst     io.Writer
+  prefix  string
+  buffer  bool
+  buf     []byte
+}
+
+/**
+ * Create a line writer for a generation of a process
+ */
+func newLineWriter(dst io.Writer, name string, gen int) *lineWriter {
+  w := &lineWriter{dst:dst, buffer:conf.Prefix || conf.Timestamps}
+  if conf.Prefix {
+    w.prefix = fmt.Sprintf("[%v:%d] ", name, gen)
+    if conf.Color {
+      w.prefix = colorPrefix + w.prefix + colorReset
+    }
+  }
+  return w
+}
+
+/**
+ * Write output
+ */
+func (w *lineWriter) Write(p []byte) (int, error) {
+  if !w.buffer {
+    output.Lock()
+    defer output.Unlock()
+    return w.dst.Write(p)
+  }
+  
+  w.buf = append(w.buf, p...)
+  for {
+    x := bytes.IndexByte(w.buf, '\n')
+    if x < 0 {
+      break
+    }
+    err := w.line(w.buf[:x+1])
+    w.buf = w.buf[x+1:]
+    if err != nil {
+      return len(p), err
+    }
+  }
+  
+  // don't hold on to runaway lines indefinitely
+  if len(w.buf) >= 64 << 10 {
+    return len(p), w.Flush()
+  }
+  
+  return len(p), nil
+}
+
+/**
+ * Write out any partial line
+ */
+func (w *lineWriter) Flush() error {
+  if len(w.buf) < 1 {
+    return nil
+  }
+  err := w.line(append(w.buf, '\n'))
+  w.buf = nil
+  return err
+}
+
+/**
+ * Write a complete, tagged line
+ */
+func (w *lineWriter) line(l []byte) error {
+  var b bytes.Buffer
+  if conf.Timestamps {
+    b.WriteString(time.Now().Format("15:04:05.000 "))
+  }
+  b.WriteString(w.prefix)
+  b.Write(l)
+  
+  output.Lock()
+  defer output.Unlock()
+  _, err := w.dst.Write(b.Bytes())
+  return err
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "strings"
+  "strconv"
+  "syscall"
+)
+
+/**
+ * Check for a PID file left behind by a previous run. If the process it
+ * names is still running we refuse to continue, otherwise the file is stale
+ * and is removed.
+ */
+func checkPidfile(p string) error {
+  data, err := os.ReadFile(p)
+  if os.IsNotExist(err) {
+    return nil
+  }else if err != nil {
+    return err
+  }
+  
+  pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+  if err == nil && pid > 0 {
+    if err := syscall.Kill(pid, 0); err == nil || err == syscall.EPERM {
+      return fmt.Errorf("Process [%d] named by PID file is still running: %v", pid, p)
+    }
+  }
+  
+  logf("Removing stale PID file: %v", p)
+  return os.Remove(p)
+}
+
+/**
+ * Write a PID file. The file is replaced atomically so readers never observe
+ * a partially-written PID. A PID file for the managed process is owned by the
+ * user it runs as.
+ */
+func writePidfile(p string, pid int, child bool) error {
+  tmp := p +".tmp"
+  os.Remove(tmp) // left over from a failed attempt
+  err := writeFile(tmp, []byte(fmt.Sprintf("%d\n", pid)), 0644)
+  if err == nil && child {
+    err = chown(tmp)
+  }
+  if err != nil {
+    os.Remove(tmp)
+    return err
+  }
+  err = os.Rename(tmp, p)
+  if err != nil {
+    os.Remove(tmp)
+    return err
+  }
+  return nil
+}
+
+/**
+ * Remove our PID files
+ */
+func removePidfiles() {
+  for _, e := range []string{conf.Pidfile, conf.ChildPidfile} {
+    if e != "" {
+      os.Remove(e)
+    }
+  }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+  "os"
+  "fmt"
+  "os/exec"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestCheckPidfile(t *testing.T) {
+  dir := t.TempDir()
+  p := filepath.Join(dir, "hotswap.pid")
+  
+  // nothing to check
+  assert.Nil(t, checkPidfile(p))
+  
+  // we're certainly still running
+  err := os.WriteFile(p, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644)
+  if !assert.Nil(t, err) { return }
+  assert.NotNil(t, checkPidfile(p))
+  _, err = os.Stat(p)
+  assert.Nil(t, err)
+  
+  // a process which has exited and been reaped is stale
+  cmd := exec.Command("true")
+  err = cmd.Run()
+  if !assert.Nil(t, err) { return }
+  err = os.WriteFile(p, []byte(fmt.Sprintf("%d\n", cmd.Process.Pid)), 0644)
+  if !assert.Nil(t, err) { return }
+  assert.Nil(t, checkPidfile(p))
+  _, err = os.Stat(p)
+  assert.True(t, os.IsNotExist(err))
+  
+  // so is one we can't make sense of
+  err = os.WriteFile(p, []byte("garbage\n"), 0644)
+  if !assert.Nil(t, err) { return }
+  assert.Nil(t, checkPidfile(p))
+  _, err = os.Stat(p)
+  assert.True(t, os.IsNotExist(err))
+}
+
+func TestWritePidfile(t *testing.T) {
+  dir := t.TempDir()
+  p := filepath.Join(dir, "hotswap.pid")
+  victim := filepath.Join(dir, "victim")
+  
+  err := writePidfile(p, 123, false)
+  if !assert.Nil(t, err) { return }
+  data, err := os.ReadFile(p)
+  assert.Nil(t, err)
+  assert.Equal(t, "123\n", string(data))
+  
+  // the file is replaced rather than rewritten, so anyone holding the old one
+  // never sees it change, and whatever is left at the temporary path is never
+  // followed
+  f, err := os.Open(p)
+  if !assert.Nil(t, err) { return }
+  defer f.Close()
+  err = os.WriteFile(victim, []byte("victim\n"), 0644)
+  if !assert.Nil(t, err) { return }
+  err = os.Symlink(victim, p +".tmp")
+  if !assert.Nil(t, err) { return }
+  
+  err = writePidfile(p, 4567, false)
+  if !assert.Nil(t, err) { return }
+  data, err = os.ReadFile(p)
+  assert.Nil(t, err)
+  assert.Equal(t, "4567\n", string(data))
+  
+  old := make([]byte, 16)
+  n, _ := f.Read(old)
+  assert.Equal(t, "123\n", string(old[:n]))
+  
+  data, err = os.ReadFile(victim)
+  assert.Nil(t, err)
+  assert.Equal(t, "victim\n", string(data))
+  _, err = os.Lstat(p +".tmp")
+  assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+  "os"
+  "os/exec"
+  "fmt"
+  "net"
+  "sync"
+  "time"
+  "bytes"
+  "errors"
+  "context"
+  "strings"
+  "strconv"
+  "syscall"
+  "net/http"
+  "path/filepath"
+)
+
+// how long to collect output from a hung process after asking it to dump
+const dumpTimeout = time.Second * 5
+
+/**
+ * A liveness probe
+ */
+type probe struct {
+  kind      string
+  target    string
+  interval  time.Duration
+  timeout   time.Duration
+  failures  int
+}
+
+/**
+ * Parse a probe in the form '<kind>[,<option>=<value>...][:<target>]'. The
+ * kind is one of 'http', 'tcp', 'exec' or 'heartbeat'; options are
+ * 'interval', 'timeout' and 'failures', which override the defaults given.
+ */
+func parseProbe(s string, d probe) (*probe, error) {
+  p := d
+  
+  opts := s
+  if x := strings.Index(s, ":"); x >= 0 {
+    opts, p.target = s[:x], s[x+1:]
+  }
+  
+  f := strings.Split(opts, ",")
+  switch p.kind = f[0]; p.kind {
+    case "http", "tcp", "exec":
+      if p.target == "" {
+        return nil, fmt.Errorf("Probe requires a target: %v", s)
+      }
+    case "heartbeat":
+      if p.target != "" {
+        return nil, fmt.Errorf("Heartbeat probe does not take a target: %v", s)
+      }
+    default:
+      return nil, fmt.Errorf("Unknown probe, expected 'http', 'tcp', 'exec' or 'heartbeat': %v", s)
+  }
+  
+  for _, e := range f[1:] {
+    x := strings.Index(e, "=")
+    if x < 0 {
+      return nil, fmt.Errorf("Invalid probe option, expected '<option>=<value>': %v", e)
+    }
+    var err error
+    switch k, v := e[:x], e[x+1:]; k {
+      case "interval":
+        p.interval, err = time.ParseDuration(v)
+      case "timeout":
+        p.timeout, err = time.ParseDuration(v)
+      case "failures":
+        p.failures, err = strconv.Atoi(v)
+      default:
+        err = fmt.Errorf("Unknown option")
+    }
+    if err != nil {
+      return nil, fmt.Errorf("Invalid probe option: %v: %v", e, err)
+    }
+  }
+  if p.interval <= 0 || p.timeout <= 0 || p.failures < 1 {
+    return nil, fmt.Errorf("Probe interval, timeout and failures must be positive: %v", s)
+  }
+  
+  return &p, nil
+}
+
+/**
+ * Describe
+ */
+func (p *probe) String() string {
+  if p.target == "" {
+    return p.kind
+  }
+  return p.kind +":"+ p.target
+}
+
+/**
+ * Check once. Heartbeat probes pass if a heartbeat has arrived since the
+ * specified time.
+ */
+func (p *probe) Check(since time.Time) error {
+  switch p.kind {
+    case "http":
+      client := &http.Client{Timeout:p.timeout}
+      rsp, err := client.Get(p.target)
+      if err != nil {
+        return err
+      }
+      rsp.Body.Close()
+      if rsp.StatusCode >= 400 {
+        return fmt.Errorf("Unexpected status: %v", rsp.Status)
+      }
+      return nil
+      
+    case "tcp":
+      conn, err := net.DialTimeout("tcp", p.target, p.timeout)
+      if err != nil {
+        return err
+      }
+      return conn.Close()
+      
+    case "exec":
+      cx, cancel := context.WithTimeout(context.Background(), p.timeout)
+      defer cancel()
+      cmd := exec.CommandContext(cx, "/bin/sh", "-c", p.target)
+      cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+      cmd.Cancel = func() error {
+        return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+      }
+      out, err := cmd.CombinedOutput()
+      if cx.Err() != nil {
+        return fmt.Errorf("Timed out after %v", p.timeout)
+      }else if err != nil {
+        return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+      }
+      return nil
+      
+    case "heartbeat":
+      if beats.Last().Before(since) {
+        return errors.New("No heartbeat received")
+      }
+      return nil
+      
+  }
+  return fmt.Errorf("Unknown probe: %v", p.kind)
+}
+
+/**
+ * Probe a process until the returned function is called. After a grace period
+ * for startup, each probe is checked at its own interval; once any of them
+ * fails too many times in a row, the process is asked to dump its state, its
+ * output is saved and it's restarted.
+ */
+func watchProbes(p *os.Process, name string, probes []*probe, grace time.Duration) func() {
+  done := make(chan struct{})
+  var hung sync.Once
+  
+  for _, e := range probes {
+    go func(e *probe) {
+      select {
+        case <- done:
+          return
+        case <- time.After(grace):
+      }
+      
+      fails := 0
+      for {
+        since := time.Now()
+        select {
+          case <- done:
+            return
+          case <- time.After(e.interval):
+        }
+        
+        err := e.Check(since)
+        if err == nil {
+          fails = 0
+          continue
+        }
+        
+        fails++
+        if conf.Verbose {
+          logf("Liveness probe %v failed (%d/%d): %v", e, fails, e.failures, err)
+        }
+        if fails >= e.failures {
+          hung.Do(func() {
+            logf("Liveness probe %v failed %d times, last with: %v", e, fails, err)
+            dump(p, name, done)
+          })
+          return
+        }
+      }
+    }(e)
+  }
+  
+  return func() {
+    close(done)
+  }
+}
+
+/**
+ * Ask a hung process to dump its state (Go programs print the stacks of all
+ * goroutines on SIGQUIT), save whatever it writes and restart it.
+ */
+func dump(p *os.Process, name string, done <-chan struct{}) {
+  _, out, stop := tap.Follow()
+  defer stop()
+  
+  err := signalProcess(p, syscall.SIGQUIT)
+  if err != nil {
+    logf("Could not signal hung process: %v", err)
+  }
+  
+  var buf bytes.Buffer
+  deadline := time.After(dumpTimeout)
+  exited := false
+  collect:
+  for {
+    select {
+      case b := <- out:
+        buf.Write(b)
+      case <- done:
+        exited = true
+        break collect
+      case <- deadline:
+        break collect
+    }
+  }
+  for { // pick up anything still buffered
+    select {
+      case b := <- out:
+        buf.Write(b)
+        continue
+      default:
+    }
+    break
+  }
+  
+  if buf.Len() > 0 {
+    dir := os.TempDir()
+    if logs != nil {
+      dir = logs.dir
+    }
+    f := filepath.Join(dir, fmt.Sprintf("hung-%v-%d-%v.log", name, p.Pid, time.Now().Format("20060102T150405")))
+    err = writeFile(f, buf.Bytes(), 0644)
+    if err == nil {
+      err = chown(f)
+    }
+    if err != nil {
+      logf("Could not save output of hung process: %v", err)
+    }else{
+      logf("Output of hung process saved to: %v", f)
+    }
+  }
+  
+  if !exited {
+    term(p)
+  }
+}
+
+/**
+ * Receives heartbeats from the managed process
+ */
+type heartbeats struct {
+  sync.Mutex
+  path  string    // where the socket is
+  addr  string    // where the managed process sees it
+  last  time.Time
+}
+
+// heartbeats, if there's a probe which wants them
+var beats *heartbeats
+
+/**
+ * Listen for heartbeats on a unix datagram socket at the specified path. The
+ * managed process sees the socket at addr, which differs when it's in a chroot.
+ * The socket belongs to the user the process runs as, since sending to it
+ * requires write permission.
+ */
+func listenHeartbeats(p, addr string) (*heartbeats, error) {
+  os.Remove(p) // datagram sockets can't tell us if someone else is listening
+  conn, err := net.ListenPacket("unixgram", p)
+  if err == nil {
+    err = chown(p)
+    if err != nil {
+      conn.Close()
+      os.Remove(p)
+    }
+  }
+  if err != nil {
+    return nil, err
+  }
+  h := &heartbeats{path:p, addr:addr}
+  go func() {
+    b := make([]byte, 64)
+    for {
+      _, _, err := conn.ReadFrom(b)
+      if err != nil {
+        return
+      }
+      h.Lock()
+      h.last = time.Now()
+      h.Unlock()
+    }
+  }()
+  return h, nil
+}
+
+/**
+ * When the last heartbeat arrived
+ */
+func (h *heartbeats) Last() time.Time {
+  if h == nil {
+    return time.Time{}
+  }
+  h.Lock()
+  defer h.Unlock()
+  return h.last
+}
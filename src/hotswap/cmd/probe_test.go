@@ -0,0 +1,56 @@
+package main
+
+import (
+  "time"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestParseProbe(t *testing.T) {
+  d := probe{interval:time.Second * 5, timeout:time.Second * 2, failures:3}
+  
+  // only the first colon separates the target, which may contain more
+  p, err := parseProbe("http:http://localhost:8080/health", d)
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, "http", p.kind)
+  assert.Equal(t, "http://localhost:8080/health", p.target)
+  assert.Equal(t, time.Second * 5, p.interval)
+  assert.Equal(t, time.Second * 2, p.timeout)
+  assert.Equal(t, 3, p.failures)
+  
+  p, err = parseProbe("tcp,interval=1s,timeout=500ms,failures=5:localhost:8080", d)
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, "tcp", p.kind)
+  assert.Equal(t, "localhost:8080", p.target)
+  assert.Equal(t, time.Second, p.interval)
+  assert.Equal(t, time.Millisecond * 500, p.timeout)
+  assert.Equal(t, 5, p.failures)
+  
+  p, err = parseProbe("exec,failures=1:./check.sh --quick", d)
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, "./check.sh --quick", p.target)
+  assert.Equal(t, 1, p.failures)
+  assert.Equal(t, time.Second * 5, p.interval)
+  
+  p, err = parseProbe("heartbeat,interval=10s", d)
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, "heartbeat", p.kind)
+  assert.Equal(t, "", p.target)
+  assert.Equal(t, time.Second * 10, p.interval)
+  
+  // defaults are left alone
+  assert.Equal(t, time.Second * 5, d.interval)
+  
+  for _, e := range []string{
+    "", "bogus:x", "http", "tcp:", "exec,interval=1s", "heartbeat:localhost:8080",
+    "tcp,interval:localhost:8080", "tcp,bogus=1:localhost:8080", "tcp,interval=soon:localhost:8080", "tcp,failures=many:localhost:8080",
+    "tcp,interval=0s:localhost:8080", "tcp,timeout=-1s:localhost:8080", "tcp,failures=0:localhost:8080", "tcp,failures=-2:localhost:8080",
+  } {
+    _, err = parseProbe(e, d)
+    assert.NotNil(t, err, e)
+  }
+  
+  // defaults must be usable, too
+  _, err = parseProbe("heartbeat", probe{interval:time.Second, timeout:time.Second})
+  assert.NotNil(t, err)
+}
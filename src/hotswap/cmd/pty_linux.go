@@ -0,0 +1,116 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+  "os"
+  "fmt"
+  "unsafe"
+  "syscall"
+)
+
+/**
+ * Perform an ioctl
+ */
+func ioctl(fd, req, arg uintptr) error {
+  _, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, arg)
+  if e != 0 {
+    return e
+  }
+  return nil
+}
+
+/**
+ * Determine if pseudo-terminals are supported
+ */
+func checkPty() error {
+  return nil
+}
+
+/**
+ * Open a new pseudo-terminal, returning the master and slave ends
+ */
+func openPty() (*os.File, *os.File, error) {
+  m, err := os.OpenFile("/dev/ptmx", os.O_RDWR | syscall.O_NOCTTY, 0)
+  if err != nil {
+    return nil, nil, err
+  }
+  
+  var unlock int32
+  err = ioctl(m.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock)))
+  if err != nil {
+    m.Close()
+    return nil, nil, err
+  }
+  
+  var n uint32
+  err = ioctl(m.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n)))
+  if err != nil {
+    m.Close()
+    return nil, nil, err
+  }
+  
+  s, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR | syscall.O_NOCTTY, 0)
+  if err != nil {
+    m.Close()
+    return nil, nil, err
+  }
+  
+  return m, s, nil
+}
+
+/**
+ * Terminal window size
+ */
+type winsize struct {
+  Rows, Cols, X, Y uint16
+}
+
+/**
+ * Copy the window size of one terminal to another
+ */
+func copyWinsize(dst, src *os.File) error {
+  var ws winsize
+  err := ioctl(src.Fd(), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&ws)))
+  if err != nil {
+    return err
+  }
+  return ioctl(dst.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
+
+/**
+ * Put a terminal into raw input mode, returning its previous state. Output
+ * processing is left alone, so our own messages are still printed properly.
+ * Signal generation is only left on if requested; otherwise keys like Ctrl-C
+ * are read as input, so they can be passed on to a pseudo-terminal.
+ */
+func makeRaw(f *os.File, signals bool) (*syscall.Termios, error) {
+  var prev syscall.Termios
+  err := ioctl(f.Fd(), syscall.TCGETS, uintptr(unsafe.Pointer(&prev)))
+  if err != nil {
+    return nil, err
+  }
+  
+  raw := prev
+  raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+  raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.IEXTEN
+  if !signals {
+    raw.Lflag &^= syscall.ISIG
+  }
+  raw.Cc[syscall.VMIN] = 1
+  raw.Cc[syscall.VTIME] = 0
+  
+  err = ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(&raw)))
+  if err != nil {
+    return nil, err
+  }
+  return &prev, nil
+}
+
+/**
+ * Restore the state of a terminal
+ */
+func restoreTerm(f *os.File, state *syscall.Termios) error {
+  return ioctl(f.Fd(), syscall.TCSETS, uintptr(unsafe.Pointer(state)))
+}
@@ -0,0 +1,47 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+  "os"
+  "errors"
+  "syscall"
+)
+
+var errNoPty = errors.New("Pseudo-terminals are only supported on Linux")
+
+/**
+ * Determine if pseudo-terminals are supported
+ */
+func checkPty() error {
+  return errNoPty
+}
+
+/**
+ * Open a new pseudo-terminal
+ */
+func openPty() (*os.File, *os.File, error) {
+  return nil, nil, errNoPty
+}
+
+/**
+ * Copy the window size of one terminal to another
+ */
+func copyWinsize(dst, src *os.File) error {
+  return errNoPty
+}
+
+/**
+ * Put a terminal into raw input mode
+ */
+func makeRaw(f *os.File, signals bool) (*syscall.Termios, error) {
+  return nil, errNoPty
+}
+
+/**
+ * Restore the state of a terminal
+ */
+func restoreTerm(f *os.File, state *syscall.Termios) error {
+  return errNoPty
+}
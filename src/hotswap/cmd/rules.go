@@ -0,0 +1,189 @@
+package main
+
+import (
+  "os"
+  "os/exec"
+  "fmt"
+  "sync"
+  "path"
+  "strings"
+  "syscall"
+)
+
+/**
+ * Rule actions
+ */
+const (
+  actionRestart = "restart"
+  actionRun     = "run"
+  actionSignal  = "signal"
+)
+
+/**
+ * Signals which may be named on the command line
+ */
+var signalNames = map[string]syscall.Signal{
+  "HUP":    syscall.SIGHUP,
+  "INT":    syscall.SIGINT,
+  "QUIT":   syscall.SIGQUIT,
+  "KILL":   syscall.SIGKILL,
+  "TERM":   syscall.SIGTERM,
+  "USR1":   syscall.SIGUSR1,
+  "USR2":   syscall.SIGUSR2,
+  "WINCH":  syscall.SIGWINCH,
+}
+
+/**
+ * A rule maps files matching a pattern to the action taken when they change.
+ * Each rule groups its own events.
+ */
+type rule struct {
+  sync.Mutex
+  pattern string
+  action  string
+  command string
+  signal  syscall.Signal
+  group   *grouper
+}
+
+/**
+ * Parse a rule in the form '<pattern>=<action>', where the action is one of:
+ *
+ *   restart          rebuild (if there is a build step) and restart
+ *   run:<command>    run a shell command without restarting
+ *   signal:<name>    send a signal, e.g. HUP, to the managed process
+ *
+ */
+func parseRule(s string) (*rule, error) {
+  x := strings.Index(s, "=")
+  if x < 1 {
+    return nil, fmt.Errorf("Invalid rule, expected '<pattern>=<action>': %v", s)
+  }
+  
+  r := &rule{pattern:s[:x]}
+  _, err := path.Match(r.pattern, "")
+  if err != nil {
+    return nil, fmt.Errorf("Invalid rule pattern: %v", r.pattern)
+  }
+  
+  a, v := s[x+1:], ""
+  if x = strings.Index(a, ":"); x > 0 {
+    a, v = a[:x], a[x+1:]
+  }
+  
+  switch r.action = a; a {
+    case actionRestart:
+      if v != "" {
+        return nil, fmt.Errorf("Restart takes no argument: %v", s)
+      }
+    case actionRun:
+      if v == "" {
+        return nil, fmt.Errorf("Run requires a command: %v", s)
+      }
+      r.command = v
+    case actionSignal:
+      sig, ok := signalNames[strings.ToUpper(strings.TrimPrefix(v, "SIG"))]
+      if !ok {
+        return nil, fmt.Errorf("Unknown signal: %v", v)
+      }
+      r.signal = sig
+    default:
+      return nil, fmt.Errorf("Unknown action: %v", a)
+  }
+  
+  return r, nil
+}
+
+/**
+ * Describe
+ */
+func (r *rule) String() string {
+  switch r.action {
+    case actionRun:
+      return fmt.Sprintf("%v=%v:%v", r.pattern, r.action, r.command)
+    case actionSignal:
+      return fmt.Sprintf("%v=%v:%v", r.pattern, r.action, r.signal)
+    default:
+      return fmt.Sprintf("%v=%v", r.pattern, r.action)
+  }
+}
+
+/**
+ * Match a pattern against a path. Patterns without a separator match the file
+ * name; patterns with one, like 'config/*.yaml', match the same number of
+ * trailing path elements.
+ */
+func matchPattern(p, f string) (bool, error) {
+  n := strings.Count(p, "/")
+  if n > 0 {
+    e := strings.Split(path.Clean(f), "/")
+    if len(e) <= n {
+      return false, nil
+    }
+    f = strings.Join(e[len(e)-n-1:], "/")
+  }else{
+    f = path.Base(f)
+  }
+  return path.Match(p, f)
+}
+
+/**
+ * Find the first rule which applies to a path
+ */
+func ruleFor(rules []*rule, f string) *rule {
+  for _, r := range rules {
+    if m, _ := matchPattern(r.pattern, f); m {
+      return r
+    }
+  }
+  return nil
+}
+
+/**
+ * Note an event for this rule
+ */
+func (r *rule) Event() {
+  if r.action == actionRestart {
+    event()
+    return
+  }
+  r.Lock()
+  defer r.Unlock()
+  if r.group == nil {
+    r.group = newGrouper(conf.Delay, func() error {
+      r.Lock()
+      r.group = nil
+      r.Unlock()
+      r.fire()
+      return nil
+    })
+  }
+  r.group.Event()
+}
+
+/**
+ * Carry out the action for this rule
+ */
+func (r *rule) fire() {
+  switch r.action {
+    case actionRun:
+      logf("[%v] %v", r.pattern, r.command)
+      cmd := exec.Command("/bin/sh", "-c", r.command)
+      cmd.Stdout = os.Stdout
+      cmd.Stderr = os.Stderr
+      err := cmd.Run()
+      if err != nil {
+        logf("[%v] Command failed: %v", r.pattern, err)
+      }
+    case actionSignal:
+      p := process()
+      if p == nil {
+        return
+      }
+      logf("[%v] Sending %v to process...", r.pattern, r.signal)
+      err := signalProcess(p, r.signal)
+      if err != nil {
+        logf("[%v] Could not signal process: %v", r.pattern, err)
+      }
+  }
+}
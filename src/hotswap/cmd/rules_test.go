@@ -0,0 +1,62 @@
+package main
+
+import (
+  "testing"
+  "syscall"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestParseRule(t *testing.T) {
+  
+  r, err := parseRule("*.go=restart")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, "*.go", r.pattern)
+  assert.Equal(t, actionRestart, r.action)
+  
+  r, err = parseRule("*.css=run:make assets")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, actionRun, r.action)
+  assert.Equal(t, "make assets", r.command)
+  
+  r, err = parseRule("config/*.yaml=signal:SIGHUP")
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, actionSignal, r.action)
+  assert.Equal(t, syscall.SIGHUP, r.signal)
+  
+  for _, e := range []string{"*.go", "=restart", "*.go=explode", "*.go=run:", "*.go=signal:NOPE", "[=restart"} {
+    _, err = parseRule(e)
+    assert.NotNil(t, err, e)
+  }
+  
+}
+
+func TestMatchPattern(t *testing.T) {
+  tests := []struct{
+    pattern string
+    path    string
+    match   bool
+  }{
+    {"*.go", "main.go", true},
+    {"*.go", "src/app/main.go", true},
+    {"*.go", "src/app/main.css", false},
+    {"config/*.yaml", "app/config/dev.yaml", true},
+    {"config/*.yaml", "config/dev.yaml", true},
+    {"config/*.yaml", "dev.yaml", false},
+    {"config/*.yaml", "app/other/dev.yaml", false},
+  }
+  for _, e := range tests {
+    m, err := matchPattern(e.pattern, e.path)
+    if assert.Nil(t, err) {
+      assert.Equal(t, e.match, m, "%v ~ %v", e.pattern, e.path)
+    }
+  }
+}
+
+func TestRuleFor(t *testing.T) {
+  a, _ := parseRule("*.tmpl=run:make")
+  b, _ := parseRule("*=restart")
+  rules := []*rule{a, b}
+  assert.Equal(t, a, ruleFor(rules, "views/index.tmpl"))
+  assert.Equal(t, b, ruleFor(rules, "main.go"))
+  assert.Nil(t, ruleFor(rules[:1], "main.go"))
+}
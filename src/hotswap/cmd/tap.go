@@ -0,0 +1,74 @@
+package main
+
+import (
+  "sync"
+)
+
+/**
+ * Keeps the most recent output from the managed process and passes new output
+ * on to anyone following it.
+ */
+type outputTap struct {
+  sync.Mutex
+  recent    []byte
+  max       int
+  followers map[chan []byte]struct{}
+}
+
+/**
+ * Create a tap which keeps up to the specified number of bytes
+ */
+func newOutputTap(max int) *outputTap {
+  return &outputTap{max:max, followers:make(map[chan []byte]struct{})}
+}
+
+/**
+ * Write output. Followers which aren't keeping up miss out rather than hold
+ * up the managed process.
+ */
+func (t *outputTap) Write(p []byte) (int, error) {
+  t.Lock()
+  defer t.Unlock()
+  
+  t.recent = append(t.recent, p...)
+  if len(t.recent) > t.max {
+    t.recent = append([]byte(nil), t.recent[len(t.recent)-t.max:]...)
+  }
+  
+  if len(t.followers) > 0 {
+    c := append([]byte(nil), p...)
+    for f := range t.followers {
+      select {
+        case f <- c:
+        default:
+      }
+    }
+  }
+  
+  return len(p), nil
+}
+
+/**
+ * Obtain recent output
+ */
+func (t *outputTap) Recent() []byte {
+  t.Lock()
+  defer t.Unlock()
+  return append([]byte(nil), t.recent...)
+}
+
+/**
+ * Follow output. This returns recent output, a channel which delivers new
+ * output and a function which stops following.
+ */
+func (t *outputTap) Follow() ([]byte, <-chan []byte, func()) {
+  t.Lock()
+  defer t.Unlock()
+  f := make(chan []byte, 256)
+  t.followers[f] = struct{}{}
+  return append([]byte(nil), t.recent...), f, func() {
+    t.Lock()
+    defer t.Unlock()
+    delete(t.followers, f)
+  }
+}
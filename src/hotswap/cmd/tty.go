@@ -0,0 +1,179 @@
+package main
+
+import (
+  "io"
+  "os"
+  "os/exec"
+  "os/signal"
+  "sync"
+  "time"
+  "syscall"
+)
+
+/**
+ * Our terminal, while a managed process is running on a pseudo-terminal
+ */
+var terminal struct {
+  sync.Mutex
+  state   *syscall.Termios
+  target  *os.File
+  pumping bool
+}
+
+/**
+ * A managed process running on a pseudo-terminal
+ */
+type ptySession struct {
+  master  *os.File
+  slave   *os.File
+  copied  chan struct{}
+}
+
+/**
+ * Attach a command to a new pseudo-terminal. Everything it writes is copied
+ * to the provided writer.
+ */
+func attachPty(cmd *exec.Cmd, out io.Writer) (*ptySession, error) {
+  m, s, err := openPty()
+  if err != nil {
+    return nil, err
+  }
+  
+  copyWinsize(m, os.Stdin) // if we're on a terminal ourselves
+  
+  cmd.Stdin = s
+  cmd.Stdout = s
+  cmd.Stderr = s
+  if cmd.SysProcAttr == nil {
+    cmd.SysProcAttr = &syscall.SysProcAttr{}
+  }
+  // keep whatever else is configured, such as credentials or a chroot
+  cmd.SysProcAttr.Setpgid = false // the session leader leads its group, too
+  cmd.SysProcAttr.Setsid = true
+  cmd.SysProcAttr.Setctty = true
+  
+  p := &ptySession{m, s, make(chan struct{})}
+  go func() {
+    io.Copy(out, m)
+    close(p.copied)
+  }()
+  
+  return p, nil
+}
+
+/**
+ * Note that the command has started. Our copy of the slave is closed, input
+ * is forwarded to the process and our terminal is put into raw mode.
+ */
+func (p *ptySession) Started() {
+  p.slave.Close()
+  
+  terminal.Lock()
+  terminal.target = p.master
+  terminal.Unlock()
+  
+  rawTerminal()
+  pumpInput()
+}
+
+/**
+ * Finish with the session once the command has exited. Remaining output is
+ * drained, unless descendants are holding the terminal open, and our terminal
+ * is restored.
+ */
+func (p *ptySession) Finish() {
+  select {
+    case <- p.copied:
+    case <- time.After(time.Second):
+  }
+  
+  terminal.Lock()
+  if terminal.target == p.master {
+    terminal.target = nil
+  }
+  terminal.Unlock()
+  
+  p.master.Close()
+  if !conf.Keys {
+    restoreTerminal() // otherwise we're still reading keys
+  }
+}
+
+/**
+ * Put our terminal into raw mode, if it is one and it isn't already
+ */
+func rawTerminal() {
+  terminal.Lock()
+  defer terminal.Unlock()
+  if terminal.state == nil && isTerminal(os.Stdin) {
+    s, err := makeRaw(os.Stdin, !conf.TTY) // on a pseudo-terminal, Ctrl-C is for the process
+    if err != nil {
+      logf("Could not configure terminal: %v", err)
+    }else{
+      terminal.state = s
+    }
+  }
+}
+
+/**
+ * Restore our terminal to the state it was in before it was made raw
+ */
+func restoreTerminal() {
+  terminal.Lock()
+  defer terminal.Unlock()
+  if terminal.state != nil {
+    restoreTerm(os.Stdin, terminal.state)
+    terminal.state = nil
+  }
+}
+
+/**
+ * Start reading our input, if we aren't already
+ */
+func pumpInput() {
+  terminal.Lock()
+  defer terminal.Unlock()
+  if !terminal.pumping {
+    terminal.pumping = true
+    go pump()
+  }
+}
+
+/**
+ * Handle our input and forward it, along with window size changes, to the
+ * current pseudo-terminal, if any. Input which arrives between generations is
+ * discarded.
+ */
+func pump() {
+  winch := make(chan os.Signal, 1)
+  signal.Notify(winch, syscall.SIGWINCH)
+  go func() {
+    for range winch {
+      terminal.Lock()
+      if terminal.target != nil {
+        copyWinsize(terminal.target, os.Stdin)
+      }
+      terminal.Unlock()
+    }
+  }()
+  
+  buf := make([]byte, 4 << 10)
+  for {
+    n, err := os.Stdin.Read(buf)
+    p := buf[:n]
+    if conf.Keys {
+      p = filterKeys(p)
+    }
+    if len(p) > 0 {
+      terminal.Lock()
+      t := terminal.target
+      terminal.Unlock()
+      if t != nil {
+        t.Write(p)
+      }
+    }
+    if err != nil {
+      return
+    }
+  }
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+  "io"
+  "os/exec"
+  "syscall"
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestAttachPtyKeepsProcAttr(t *testing.T) {
+  cmd := exec.Command("/bin/true")
+  cred := &syscall.Credential{Uid:65534, Gid:65534}
+  cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred, Chroot: "/jail"}
+  
+  p, err := attachPty(cmd, io.Discard)
+  if err != nil {
+    t.Skipf("No pseudo-terminal available: %v", err)
+  }
+  defer p.master.Close()
+  defer p.slave.Close()
+  
+  assert.Equal(t, cred, cmd.SysProcAttr.Credential)
+  assert.Equal(t, "/jail", cmd.SysProcAttr.Chroot)
+  assert.True(t, cmd.SysProcAttr.Setsid)
+  assert.True(t, cmd.SysProcAttr.Setctty)
+  assert.False(t, cmd.SysProcAttr.Setpgid)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+  "os"
+  "time"
+)
+
+/**
+ * Resource usage policies for the managed process
+ */
+type usagePolicy struct {
+  Interval    time.Duration // how often to sample
+  MaxRSS      int64         // restart when resident memory exceeds this many bytes...
+  MaxRSSFor   time.Duration // ...for this long
+  WarnCPU     float64       // warn when CPU use exceeds this percentage...
+  WarnCPUFor  time.Duration // ...for this long
+}
+
+/**
+ * Resource usage of a process group at a point in time
+ */
+type usage struct {
+  RSS   int64         // resident memory, in bytes
+  CPU   time.Duration // total CPU time consumed
+}
+
+/**
+ * Sample the resource usage of a process and enforce our policies until the
+ * returned function is called. Memory over the limit for long enough gets the
+ * process restarted; sustained CPU use is only reported.
+ */
+func watchUsage(p *os.Process, policy usagePolicy) func() {
+  done := make(chan struct{})
+  go func() {
+    var overRSS, overCPU time.Time
+    prev, err := sampleUsage(p.Pid)
+    if err != nil {
+      logf("Could not sample resource usage: %v", err)
+      return
+    }
+    last := time.Now()
+    
+    for {
+      select {
+        case <- done:
+          return
+        case <- time.After(policy.Interval):
+      }
+      
+      curr, err := sampleUsage(p.Pid)
+      if err != nil {
+        return // it's gone
+      }
+      now := time.Now()
+      
+      if policy.MaxRSS > 0 {
+        if curr.RSS <= policy.MaxRSS {
+          overRSS = time.Time{}
+        }else if overRSS.IsZero() {
+          overRSS = now
+        }else if now.Sub(overRSS) >= policy.MaxRSSFor {
+          logf("Process memory use (%dMB) exceeded %dMB for %v, restarting...", curr.RSS >> 20, policy.MaxRSS >> 20, policy.MaxRSSFor)
+          term(p)
+          return
+        }
+      }
+      
+      if policy.WarnCPU > 0 {
+        pct := float64(curr.CPU - prev.CPU) / float64(now.Sub(last)) * 100
+        if pct <= policy.WarnCPU {
+          overCPU = time.Time{}
+        }else if overCPU.IsZero() {
+          overCPU = now
+        }else if now.Sub(overCPU) >= policy.WarnCPUFor {
+          logf("Process CPU use has been over %.0f%% for %v (currently %.0f%%)", policy.WarnCPU, now.Sub(overCPU).Round(time.Second), pct)
+          overCPU = now // don't repeat ourselves every sample
+        }
+      }
+      
+      prev, last = curr, now
+    }
+  }()
+  return func() {
+    close(done)
+  }
+}
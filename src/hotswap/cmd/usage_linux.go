@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+  "os"
+  "time"
+  "bytes"
+  "strconv"
+  "path/filepath"
+)
+
+// clock ticks per second; this is 100 on every Linux platform we care about
+const clockTicks = 100
+
+/**
+ * Sample the resource usage of everything in a process group from /proc
+ */
+func sampleUsage(pgid int) (usage, error) {
+  var u usage
+  
+  // make sure the leader is still around, at least
+  _, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pgid)))
+  if err != nil {
+    return u, err
+  }
+  
+  m, err := filepath.Glob("/proc/[0-9]*/stat")
+  if err != nil {
+    return u, err
+  }
+  
+  page := int64(os.Getpagesize())
+  for _, e := range m {
+    data, err := os.ReadFile(e)
+    if err != nil {
+      continue // it exited
+    }
+    
+    // the command name may contain anything, so skip past it
+    x := bytes.LastIndexByte(data, ')')
+    if x < 0 {
+      continue
+    }
+    f := bytes.Fields(data[x+1:])
+    if len(f) < 22 {
+      continue
+    }
+    
+    // fields are numbered from the state, which is field 3 in proc(5)
+    if g, _ := strconv.Atoi(string(f[2])); g != pgid {
+      continue
+    }
+    utime, _ := strconv.ParseInt(string(f[11]), 10, 64)
+    stime, _ := strconv.ParseInt(string(f[12]), 10, 64)
+    rss, _ := strconv.ParseInt(string(f[21]), 10, 64)
+    
+    u.CPU += time.Duration(utime + stime) * time.Second / clockTicks
+    u.RSS += rss * page
+  }
+  
+  return u, nil
+}
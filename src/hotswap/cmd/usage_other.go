@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+  "errors"
+)
+
+/**
+ * Sample the resource usage of a process group
+ */
+func sampleUsage(pgid int) (usage, error) {
+  return usage{}, errors.New("Sampling resource usage is only supported on Linux")
+}
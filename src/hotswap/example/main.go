@@ -0,0 +1,94 @@
+package main
+
+import (
+  "io"
+  "os"
+  "fmt"
+  "time"
+  "context"
+  "net/http"
+  "sync/atomic"
+  "encoding/json"
+)
+
+import (
+  "hotswap"
+)
+
+/**
+ * A small daemon which upgrades itself in place. Rebuild the binary, then
+ * signal the running process with SIGHUP or send "upgrade" to its control
+ * socket to swap in the new one without dropping connections. Replacing the
+ * binary on disk also triggers an upgrade. The request count is handed over
+ * from one generation to the next.
+ */
+func main() {
+  var hits int64
+
+  swap, err := hotswap.New(hotswap.Config{
+    HealthWindow: time.Second * 5,
+    StateVersion: 1,
+    SaveState: func(w io.Writer) error {
+      return json.NewEncoder(w).Encode(atomic.LoadInt64(&hits))
+    },
+    OnUpgrade: func(t string, err error) {
+      if err != nil {
+        fmt.Printf("[%d] Could not upgrade (%v): %v\n", os.Getpid(), t, err)
+      }else{
+        fmt.Printf("[%d] Upgraded (%v)\n", os.Getpid(), t)
+      }
+    },
+  })
+  if err != nil {
+    panic(err)
+  }
+
+  if swap.Spawned() {
+    fmt.Printf("[%d] Spawned by generation [%d]\n", os.Getpid(), swap.Creator())
+  }else{
+    fmt.Printf("[%d] Starting\n", os.Getpid())
+  }
+
+  if v, r, err := swap.ReadState(); err == nil {
+    if v == 1 {
+      err = json.NewDecoder(r).Decode(&hits)
+    }
+    r.Close()
+    if err != nil {
+      fmt.Printf("[%d] Could not restore state: %v\n", os.Getpid(), err)
+    }
+  }
+
+  l, err := swap.Listen("tcp", "127.0.0.1:8080")
+  if err != nil {
+    panic(err)
+  }
+
+  svr := &http.Server{Handler:http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+    fmt.Fprintf(rsp, "Hello from [%d], request #%d\n", os.Getpid(), atomic.AddInt64(&hits, 1))
+  })}
+  go svr.Serve(l)
+
+  swap.UpgradeOnSignal()
+  err = swap.UpgradeOnChange()
+  if err != nil {
+    panic(err)
+  }
+  err = swap.UpgradeOnCommand(os.TempDir() +"/hotswap-example.sock")
+  if err != nil {
+    panic(err)
+  }
+
+  err = swap.Ready()
+  if err != nil {
+    panic(err)
+  }
+
+  <- swap.Exit()
+  fmt.Printf("[%d] Replaced by a new generation; draining...\n", os.Getpid())
+
+  cxt, cancel := context.WithTimeout(context.Background(), time.Second * 30)
+  defer cancel()
+  svr.Shutdown(cxt)
+  fmt.Printf("[%d] Ok, bye.\n", os.Getpid())
+}
@@ -0,0 +1,168 @@
+package hotswap
+
+import (
+  "io"
+  "os"
+  "os/exec"
+  "fmt"
+  "time"
+  "syscall"
+  "encoding/json"
+)
+
+/**
+ * What a supervisor needs to know to watch over a new generation
+ */
+type supervision struct {
+  Parent    int       `json:"parent"`
+  Child     int       `json:"child"`
+  Deadline  time.Time `json:"deadline"`
+  Binary    string    `json:"binary"`
+  Original  string    `json:"original"`
+  Args      []string  `json:"args"`
+}
+
+/**
+ * Open the image we are running from. On Linux this is available even after
+ * the file on disk has been replaced; elsewhere we open the binary path early
+ * and hold on to it, which has the same effect when builds replace the file
+ * rather than rewriting it.
+ */
+func openImage(bin string) (*os.File, error) {
+  f, err := os.Open("/proc/self/exe")
+  if err == nil {
+    return f, nil
+  }
+  return os.Open(bin)
+}
+
+/**
+ * Preserve the binary we are running from so it can be restored
+ */
+func (s *Swapper) backup() error {
+  finfo, err := s.image.Stat()
+  if err != nil {
+    return err
+  }
+
+  tmp := s.conf.Backup +".tmp"
+  dst, err := os.OpenFile(tmp, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, 0755)
+  if err != nil {
+    return err
+  }
+
+  _, err = io.Copy(dst, io.NewSectionReader(s.image, 0, finfo.Size()))
+  if cerr := dst.Close(); err == nil {
+    err = cerr
+  }
+  if err != nil {
+    os.Remove(tmp)
+    return err
+  }
+
+  return os.Rename(tmp, s.conf.Backup)
+}
+
+/**
+ * Start a supervisor from our backup binary which holds on to our listeners
+ * for the duration of the health window. If we exit and the new generation
+ * fails within that time, the supervisor restores the backup.
+ */
+func (s *Swapper) supervise(c *child, files map[string]*os.File) error {
+  sup, err := json.Marshal(supervision{
+    Parent: os.Getpid(),
+    Child: c.cmd.Process.Pid,
+    Deadline: time.Now().Add(s.conf.HealthWindow),
+    Binary: s.conf.Backup,
+    Original: s.conf.Binary,
+    Args: s.conf.Args,
+  })
+  if err != nil {
+    return err
+  }
+
+  cmd := exec.Command(s.conf.Backup, s.conf.Args...)
+  cmd.Stdout = os.Stdout
+  cmd.Stderr = os.Stderr
+
+  fds := make(map[string]int)
+  for k, f := range files {
+    fds[k] = 3 + len(cmd.ExtraFiles)
+    cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+  }
+
+  enc, err := encodeFiles(fds)
+  if err != nil {
+    return err
+  }
+
+  cmd.Env = append(environ(),
+    fmt.Sprintf("%s=%s", envSupervise, sup),
+    fmt.Sprintf("%s=%s", envListeners, enc),
+  )
+
+  err = cmd.Start()
+  if err != nil {
+    return err
+  }
+
+  go cmd.Wait()
+  return nil
+}
+
+/**
+ * Run as a supervisor. This returns the status the process should exit with.
+ */
+func supervise(conf Config, v string) int {
+  var sup supervision
+
+  err := json.Unmarshal([]byte(v), &sup)
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "hotswap: Invalid supervisor state: %v\n", err)
+    return 1
+  }
+
+  files, err := decodeFiles(os.Getenv(envListeners))
+  if err != nil {
+    fmt.Fprintf(os.Stderr, "hotswap: %v\n", err)
+    return 1
+  }
+
+  if conf.ReadyTimeout <= 0 {
+    conf.ReadyTimeout = time.Minute
+  }
+
+  for time.Now().Before(sup.Deadline) {
+    if alive(sup.Child) {
+      <- time.After(time.Millisecond * 100)
+      continue
+    }
+    if alive(sup.Parent) {
+      return 0 // our creator is still around and will carry on serving
+    }
+
+    _, err = spawn(sup.Binary, sup.Original, sup.Args, files, nil, conf.ReadyTimeout)
+    if err != nil {
+      err = fmt.Errorf("Could not restore %v after generation [%d] failed: %v", sup.Binary, sup.Child, err)
+    }
+    if conf.OnUpgrade != nil {
+      conf.OnUpgrade("rollback", err)
+    }else if err != nil {
+      fmt.Fprintf(os.Stderr, "hotswap: %v\n", err)
+    }
+    if err != nil {
+      return 1
+    }
+    return 0
+  }
+
+  return 0
+}
+
+/**
+ * Determine if a process is still running
+ */
+func alive(pid int) bool {
+  err := syscall.Kill(pid, 0)
+  return err == nil || err == syscall.EPERM
+}
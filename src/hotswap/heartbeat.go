@@ -0,0 +1,31 @@
+package hotswap
+
+import (
+  "os"
+  "net"
+  "strconv"
+)
+
+// set by the hotswap command when it expects heartbeats from the process it manages
+const envHeartbeat = "GO_HOTSWAP_HEARTBEAT"
+
+/**
+ * Tell the hotswap command managing this process that it's still alive. Call
+ * this regularly from somewhere that stops when the process hangs, such as a
+ * main loop; when the command is run with a heartbeat probe and heartbeats
+ * stop arriving, the process is restarted. If the process isn't being managed
+ * by the command, this does nothing.
+ */
+func Heartbeat() error {
+  p := os.Getenv(envHeartbeat)
+  if p == "" {
+    return nil
+  }
+  conn, err := net.Dial("unixgram", p)
+  if err != nil {
+    return err
+  }
+  defer conn.Close()
+  _, err = conn.Write([]byte(strconv.Itoa(os.Getpid())))
+  return err
+}
@@ -1,38 +1,492 @@
-package main
+package hotswap
 
 import (
+  "io"
   "os"
   "os/exec"
   "fmt"
+  "sync"
   "time"
-  // "path"
-  // "sort"
+  "errors"
   "strings"
-  // "strconv"
-  // "io/ioutil"
+  "strconv"
+  "path/filepath"
 )
 
-// import (
-//   "github.com/kardianos/osext"
-// )
-
-func main() {
-  fmt.Println(strings.Join(os.Args, ", "))
-  <- time.After(time.Second * 2)
-  
-  if os.Getenv("GO_HOTSWAP_CREATOR_PID") != "" {
-    fmt.Println("THIS IS A SPAWN PROCESS")
-    fmt.Println(os.Environ())
-    <- time.After(time.Second * 10)
-    fmt.Println("Ok, bye.")
-  }else{
-    fmt.Println("THIS IS A ROOT PROCESS")
-    cmd := exec.Command(os.Args[0], os.Args[1:]...)
-    cmd.Env = append(os.Environ(), fmt.Sprintf("GO_HOTSWAP_CREATOR_PID=%d", os.Getpid()))
-    err := cmd.Start()
-    if err != nil {
-      panic(err)
-    }
-  }
-  
+const (
+  envCreatorPid = "GO_HOTSWAP_CREATOR_PID"
+  envReadyFd    = "GO_HOTSWAP_READY_FD"
+  envListeners  = "GO_HOTSWAP_LISTENERS"
+  envSupervise  = "GO_HOTSWAP_SUPERVISE"
+  envStateFd    = "GO_HOTSWAP_STATE_FD"
+  envBinary     = "GO_HOTSWAP_BINARY"
+)
+
+// every variable we use to communicate with a new generation
+var envState = []string{envCreatorPid, envReadyFd, envListeners, envSupervise, envStateFd, envBinary}
+
+const (
+  StatusServing   = "serving"
+  StatusUpgrading = "upgrading"
+  StatusUpgraded  = "upgraded"
+)
+
+var (
+  ErrUpgrading  = errors.New("An upgrade is already in progress")
+  ErrUpgraded   = errors.New("This process has already been replaced by a new generation")
+)
+
+/**
+ * Self-upgrade configuration
+ */
+type Config struct {
+  Binary        string        // the executable to spawn on upgrade; defaults to os.Args[0]
+  Args          []string      // the arguments to spawn it with; defaults to os.Args[1:]
+  ReadyTimeout  time.Duration // how long a new generation has to signal that it's ready
+  ChangeDelay   time.Duration // how long the binary must be quiet before a change triggers an upgrade
+  OnUpgrade     func(trigger string, err error) // called after every triggered upgrade attempt
+  HealthWindow  time.Duration // how long a new generation must stay up after it's ready before we exit
+  Backup        string        // where the previous binary is preserved; defaults to Binary + ".previous"
+  SaveState     func(w io.Writer) error // serializes state for the next generation, if set
+  StateVersion  int           // the version of the state format written by SaveState
+  Verify        *Verification // if set, the binary must pass verification before it's started
+}
+
+/**
+ * A swapper manages in-process upgrades. On upgrade it spawns the binary on
+ * disk, hands it our listening sockets and waits for it to become ready, at
+ * which point this process should drain and exit.
+ */
+type Swapper struct {
+  sync.Mutex
+  conf        Config
+  creator     int
+  image       *os.File
+  ready       *os.File
+  state       *os.File
+  inherited   map[string]*os.File
+  listeners   map[string]fileListener
+  upgrading   bool
+  upgraded    bool
+  exit        chan struct{}
+}
+
+/**
+ * Create a swapper. If this process was spawned by an upgrade, the files
+ * passed to us by our creator are picked up here.
+ */
+func New(conf Config) (*Swapper, error) {
+  var err error
+
+  // if we were started to watch over a new generation, do only that
+  if v := os.Getenv(envSupervise); v != "" {
+    os.Exit(supervise(conf, v))
+  }
+
+  // a generation restored from the backup still upgrades from the original
+  if conf.Binary == "" {
+    conf.Binary = os.Getenv(envBinary)
+  }
+  if conf.Binary == "" {
+    conf.Binary, err = executable()
+    if err != nil {
+      return nil, err
+    }
+  }
+  if conf.Args == nil {
+    conf.Args = os.Args[1:]
+  }
+  if conf.ReadyTimeout <= 0 {
+    conf.ReadyTimeout = time.Minute
+  }
+  if conf.ChangeDelay <= 0 {
+    conf.ChangeDelay = time.Second
+  }
+  if conf.Backup == "" {
+    conf.Backup = conf.Binary +".previous"
+  }
+
+  s := &Swapper{
+    conf: conf,
+    inherited: make(map[string]*os.File),
+    listeners: make(map[string]fileListener),
+    exit: make(chan struct{}),
+  }
+
+  err = s.inherit()
+  if err != nil {
+    return nil, err
+  }
+
+  if conf.HealthWindow > 0 {
+    s.image, err = openImage(conf.Binary)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  return s, nil
+}
+
+/**
+ * Resolve the path to our own executable as it was invoked. We deliberately
+ * don't use os.Executable, which follows the running image rather than what
+ * is currently on disk.
+ */
+func executable() (string, error) {
+  p := os.Args[0]
+  if !strings.ContainsRune(p, filepath.Separator) {
+    return exec.LookPath(p)
+  }
+  return filepath.Abs(p)
+}
+
+/**
+ * Pick up the state handed to us by the generation that spawned us, if any.
+ */
+func (s *Swapper) inherit() error {
+  v := os.Getenv(envCreatorPid)
+  if v == "" {
+    return nil
+  }
+
+  pid, err := strconv.Atoi(v)
+  if err != nil {
+    return fmt.Errorf("Invalid creator PID: %v", v)
+  }
+  s.creator = pid
+
+  if v = os.Getenv(envReadyFd); v != "" {
+    fd, err := strconv.Atoi(v)
+    if err != nil {
+      return fmt.Errorf("Invalid ready descriptor: %v", v)
+    }
+    s.ready = os.NewFile(uintptr(fd), "ready")
+  }
+
+  if v = os.Getenv(envStateFd); v != "" {
+    fd, err := strconv.Atoi(v)
+    if err != nil {
+      return fmt.Errorf("Invalid state descriptor: %v", v)
+    }
+    s.state = os.NewFile(uintptr(fd), "state")
+  }
+
+  if v = os.Getenv(envListeners); v != "" {
+    s.inherited, err = decodeFiles(v)
+    if err != nil {
+      return err
+    }
+  }
+
+  // don't leak any of this into processes we start ourselves
+  for _, e := range envState {
+    os.Unsetenv(e)
+  }
+
+  return nil
+}
+
+/**
+ * Determine if this process was spawned by an upgrade.
+ */
+func (s *Swapper) Spawned() bool {
+  return s.creator != 0
+}
+
+/**
+ * Obtain the PID of the generation that spawned this one, or zero if this
+ * process was started normally.
+ */
+func (s *Swapper) Creator() int {
+  return s.creator
+}
+
+/**
+ * Obtain a channel which is closed once a new generation has taken over. The
+ * process should stop accepting work, drain and exit when this happens.
+ */
+func (s *Swapper) Exit() <-chan struct{} {
+  return s.exit
+}
+
+/**
+ * Describe what this generation is currently doing: serving, upgrading or
+ * upgraded (in which case it is draining and should exit).
+ */
+func (s *Swapper) Status() string {
+  s.Lock()
+  defer s.Unlock()
+  switch {
+    case s.upgraded:
+      return StatusUpgraded
+    case s.upgrading:
+      return StatusUpgrading
+    default:
+      return StatusServing
+  }
+}
+
+/**
+ * Signal to our creator that this generation is ready to take over. Any
+ * inherited files which were not claimed by now, including unread state, are
+ * closed. This is a no-op if the process was not spawned by an upgrade.
+ */
+func (s *Swapper) Ready() error {
+  s.Lock()
+  defer s.Unlock()
+
+  for k, f := range s.inherited {
+    f.Close()
+    delete(s.inherited, k)
+  }
+  if s.state != nil {
+    s.state.Close()
+    s.state = nil
+  }
+
+  if s.ready == nil {
+    return nil
+  }
+
+  _, err := s.ready.Write([]byte{1})
+  s.ready.Close()
+  s.ready = nil
+  return err
+}
+
+/**
+ * Upgrade to the binary on disk. The new generation is started with our
+ * listening sockets and this method waits for it to signal that it's ready.
+ * If it does, the exit channel is closed and the caller should drain and
+ * exit. If it doesn't, the new generation is killed and this process should
+ * carry on serving.
+ *
+ * When a health window is configured the new generation must also stay up
+ * for that long after it's ready, during which both generations serve. Our
+ * binary is preserved as a backup and a supervisor is started to restore it
+ * should we exit before the window has elapsed.
+ */
+func (s *Swapper) Upgrade() error {
+  s.Lock()
+  if s.upgraded {
+    s.Unlock()
+    return ErrUpgraded
+  }
+  if s.upgrading {
+    s.Unlock()
+    return ErrUpgrading
+  }
+  s.upgrading = true
+  files, err := s.files()
+  s.Unlock()
+
+  defer func() {
+    for _, f := range files {
+      f.Close()
+    }
+    s.Lock()
+    s.upgrading = false
+    s.Unlock()
+  }()
+
+  if err != nil {
+    return err
+  }
+
+  if s.conf.Verify != nil {
+    err = Verify(s.conf.Binary, *s.conf.Verify)
+    if err != nil {
+      return fmt.Errorf("Could not verify new binary: %v", err)
+    }
+  }
+
+  if s.conf.HealthWindow > 0 {
+    err = s.backup()
+    if err != nil {
+      return fmt.Errorf("Could not preserve previous binary: %v", err)
+    }
+  }
+
+  var save func(io.Writer) error
+  if s.conf.SaveState != nil {
+    save = s.writeState
+  }
+
+  c, err := spawn(s.conf.Binary, s.conf.Binary, s.conf.Args, files, save, s.conf.ReadyTimeout)
+  if err != nil {
+    return err
+  }
+
+  if s.conf.HealthWindow > 0 {
+    err = s.supervise(c, files)
+    if err != nil {
+      c.cmd.Process.Kill()
+      return fmt.Errorf("Could not start supervisor: %v", err)
+    }
+    err = c.healthy(s.conf.HealthWindow)
+    if err != nil {
+      return err
+    }
+  }
+
+  s.Lock()
+  s.upgraded = true
+  close(s.exit)
+  s.Unlock()
+  return nil
+}
+
+/**
+ * A spawned generation
+ */
+type child struct {
+  cmd     *exec.Cmd
+  exited  chan error
+}
+
+/**
+ * Start a new generation from bin and wait for it to become ready. The new
+ * generation treats self as its binary, which differs from bin when we're
+ * restoring a backup. If a save function is provided, it streams state to the
+ * new generation in the meantime.
+ */
+func spawn(bin, self string, args []string, files map[string]*os.File, save func(io.Writer) error, timeout time.Duration) (*child, error) {
+  rd, wr, err := os.Pipe()
+  if err != nil {
+    return nil, err
+  }
+  defer rd.Close()
+
+  cmd := exec.Command(bin, args...)
+  cmd.Stdin = os.Stdin
+  cmd.Stdout = os.Stdout
+  cmd.Stderr = os.Stderr
+  cmd.ExtraFiles = []*os.File{wr}
+
+  fds := make(map[string]int)
+  for k, f := range files {
+    fds[k] = 3 + len(cmd.ExtraFiles)
+    cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+  }
+
+  enc, err := encodeFiles(fds)
+  if err != nil {
+    wr.Close()
+    return nil, err
+  }
+
+  cmd.Env = append(environ(),
+    fmt.Sprintf("%s=%d", envCreatorPid, os.Getpid()),
+    fmt.Sprintf("%s=%d", envReadyFd, 3),
+    fmt.Sprintf("%s=%s", envListeners, enc),
+    fmt.Sprintf("%s=%s", envBinary, self),
+  )
+
+  var srd, swr *os.File
+  if save != nil {
+    srd, swr, err = os.Pipe()
+    if err != nil {
+      wr.Close()
+      return nil, err
+    }
+    cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", envStateFd, 3 + len(cmd.ExtraFiles)))
+    cmd.ExtraFiles = append(cmd.ExtraFiles, srd)
+  }
+
+  err = cmd.Start()
+  wr.Close()
+  if srd != nil {
+    srd.Close()
+  }
+  if err != nil {
+    if swr != nil {
+      swr.Close()
+    }
+    return nil, err
+  }
+
+  c := &child{cmd, make(chan error, 1)}
+  go func() {
+    c.exited <- exitError(cmd.Wait())
+  }()
+
+  ready := make(chan error, 1)
+  go func() {
+    _, err := rd.Read(make([]byte, 1))
+    ready <- err
+  }()
+
+  var saved chan error
+  if save != nil {
+    saved = make(chan error, 1)
+    go func() {
+      saved <- save(swr)
+      swr.Close()
+    }()
+  }
+
+  deadline := time.After(timeout)
+  for {
+    select {
+      case err = <- ready:
+        if err == nil {
+          return c, nil
+        }
+        err = fmt.Errorf("New generation [%d] did not signal that it's ready: %v", cmd.Process.Pid, err)
+      case err = <- c.exited:
+        return nil, fmt.Errorf("New generation [%d] exited before it was ready: %v", cmd.Process.Pid, err)
+      case err = <- saved:
+        if err == nil {
+          saved = nil
+          continue
+        }
+        err = fmt.Errorf("Could not save state for new generation [%d]: %v", cmd.Process.Pid, err)
+      case <- deadline:
+        err = fmt.Errorf("New generation [%d] did not become ready within %v", cmd.Process.Pid, timeout)
+    }
+    break
+  }
+
+  cmd.Process.Kill()
+  return nil, err
+}
+
+/**
+ * Wait out a health window, failing if the generation exits before it's over
+ */
+func (c *child) healthy(d time.Duration) error {
+  select {
+    case err := <- c.exited:
+      return fmt.Errorf("New generation [%d] exited within its health window: %v", c.cmd.Process.Pid, err)
+    case <- time.After(d):
+      return nil
+  }
+}
+
+/**
+ * Describe the way a process exited, even if it was successful
+ */
+func exitError(err error) error {
+  if err == nil {
+    return fmt.Errorf("exit status 0")
+  }
+  return err
+}
+
+/**
+ * Our environment, without any state inherited from our creator
+ */
+func environ() []string {
+  var env []string
+  outer:
+  for _, e := range os.Environ() {
+    for _, v := range envState {
+      if strings.HasPrefix(e, v +"=") {
+        continue outer
+      }
+    }
+    env = append(env, e)
+  }
+  return env
 }
@@ -2,79 +2,88 @@ package hotswap
 
 import (
   "os"
-  "fmt"
-  "path"
+  "time"
   "testing"
+  "path/filepath"
   "github.com/stretchr/testify/assert"
 )
 
-type testDriver struct {
-  version int
-}
+// a new generation which signals that it's ready and then hangs around
+const readyScript = "#!/bin/sh\nprintf x >&3\nexec sleep 5 >/dev/null 2>&1\n"
 
-func (d *testDriver) Version() (int, error) {
-  return d.version, nil
-}
+func TestUpgradeStates(t *testing.T) {
+  dir := t.TempDir()
+  bin := filepath.Join(dir, "app")
 
-func (d *testDriver) Upgrade(v Version) error {
-  fmt.Println("---> Upgrade to version", v)
-  d.version = v.Version
-  return nil
-}
+  s, err := New(Config{Binary:bin, Args:[]string{}})
+  if !assert.Nil(t, err) { return }
+  assert.False(t, s.Spawned())
+  assert.Equal(t, StatusServing, s.Status())
 
-func TestValidVersions(t *testing.T) {
-  
-  u, err := New(Config{Resources:path.Join(os.Getenv("GO_UPGRADE_TEST_RESOURCES"), "versions/001"), Driver:&testDriver{0}})
-  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) { return }
-  if !assert.Len(t, u.versions, 3) { return }
-  
-  assert.Equal(t, 1, u.versions[0].Version)
-  assert.Equal(t, []byte("1. Up"), u.versions[0].Upgrade)
-  assert.Equal(t, []byte("1. Down"), u.versions[0].Rollback)
-  
-  assert.Equal(t, 2, u.versions[1].Version)
-  assert.Equal(t, []byte("2. Up"), u.versions[1].Upgrade)
-  assert.Equal(t, []byte("2. Down"), u.versions[1].Rollback)
-  
-  assert.Equal(t, 4, u.versions[2].Version) // this is weird, but valid; versions can be sparse
-  assert.Equal(t, []byte("4. Up"), u.versions[2].Upgrade)
-  assert.Equal(t, []byte("4. Down"), u.versions[2].Rollback)
-  
-  fmt.Println(u)
-}
+  // there's nothing to upgrade to yet, so we carry on serving
+  err = s.Upgrade()
+  assert.NotNil(t, err)
+  assert.Equal(t, StatusServing, s.Status())
+
+  // an upgrade which is already running turns others away
+  s.upgrading = true
+  assert.Equal(t, StatusUpgrading, s.Status())
+  assert.Equal(t, ErrUpgrading, s.Upgrade())
+  s.upgrading = false
 
-func TestMalformedVersions(t *testing.T) {
-  
-  _, err := New(Config{Resources:path.Join(os.Getenv("GO_UPGRADE_TEST_RESOURCES"), "versions/002")})
-  if !assert.NotNil(t, err, fmt.Sprintf("%v", err)) {
-    return
+  err = os.WriteFile(bin, []byte(readyScript), 0755)
+  if !assert.Nil(t, err) { return }
+
+  err = s.Upgrade()
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, StatusUpgraded, s.Status())
+  select {
+    case <- s.Exit():
+    default:
+      t.Error("Exit channel was not closed")
   }
-  
+
+  // once replaced, there's nothing more to do
+  assert.Equal(t, ErrUpgraded, s.Upgrade())
 }
 
-func TestUpgrade(t *testing.T) {
-  
-  u, err := New(Config{Resources:path.Join(os.Getenv("GO_UPGRADE_TEST_RESOURCES"), "versions/001"), Driver:&testDriver{0}})
-  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
-    return
-  }
-  
-  n, err := u.UpgradeToVersion(2)
-  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
-    return
-  }
-  assert.Equal(t, 2, n)
-  
-  n, err = u.Upgrade()
-  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
-    return
-  }
-  assert.Equal(t, 4, n)
-  
-  n, err = u.Upgrade()
-  if !assert.Nil(t, err, fmt.Sprintf("%v", err)) {
-    return
-  }
-  assert.Equal(t, 4, n)
-  
+func TestUpgradeNotReady(t *testing.T) {
+  dir := t.TempDir()
+  bin := filepath.Join(dir, "app")
+
+  err := os.WriteFile(bin, []byte("#!/bin/sh\nexit 1\n"), 0755)
+  if !assert.Nil(t, err) { return }
+
+  s, err := New(Config{Binary:bin, Args:[]string{}})
+  if !assert.Nil(t, err) { return }
+
+  err = s.Upgrade()
+  assert.NotNil(t, err)
+  assert.Equal(t, StatusServing, s.Status())
+}
+
+func TestSpawnRestored(t *testing.T) {
+  dir := t.TempDir()
+  backup := filepath.Join(dir, "app.previous")
+  out := filepath.Join(dir, "binary")
+
+  // a generation restored from the backup is told where the original lives
+  script := "#!/bin/sh\nprintf %s \"$"+ envBinary +"\" > "+ out +"\nprintf x >&3\nexec sleep 5 >/dev/null 2>&1\n"
+  err := os.WriteFile(backup, []byte(script), 0755)
+  if !assert.Nil(t, err) { return }
+
+  c, err := spawn(backup, filepath.Join(dir, "app"), []string{}, nil, nil, time.Second * 5)
+  if !assert.Nil(t, err) { return }
+  c.cmd.Process.Kill()
+
+  b, err := os.ReadFile(out)
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, filepath.Join(dir, "app"), string(b))
+
+  // and upgrades from there rather than from its own image
+  t.Setenv(envBinary, filepath.Join(dir, "app"))
+  s, err := New(Config{Args:[]string{}})
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, filepath.Join(dir, "app"), s.conf.Binary)
+  assert.Equal(t, filepath.Join(dir, "app.previous"), s.conf.Backup)
 }
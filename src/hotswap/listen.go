@@ -0,0 +1,105 @@
+package hotswap
+
+import (
+  "os"
+  "fmt"
+  "net"
+  "encoding/json"
+)
+
+/**
+ * A listener which can be handed to a new generation
+ */
+type fileListener interface {
+  net.Listener
+  File() (*os.File, error)
+}
+
+/**
+ * Listen on the specified address. If the socket was inherited from the
+ * generation that spawned us it is reused, otherwise a new one is opened.
+ * Listeners obtained this way are passed on to the next generation.
+ */
+func (s *Swapper) Listen(network, addr string) (net.Listener, error) {
+  var l net.Listener
+  var err error
+
+  s.Lock()
+  defer s.Unlock()
+
+  name := network +":"+ addr
+  if _, ok := s.listeners[name]; ok {
+    return nil, fmt.Errorf("Already listening on %v", name)
+  }
+
+  if f, ok := s.inherited[name]; ok {
+    delete(s.inherited, name)
+    l, err = net.FileListener(f)
+    f.Close()
+  }else{
+    l, err = net.Listen(network, addr)
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  fl, ok := l.(fileListener)
+  if !ok {
+    l.Close()
+    return nil, fmt.Errorf("Unsupported listener: %v", name)
+  }
+
+  // the socket outlives us, so the next generation is responsible for it
+  if u, ok := fl.(*net.UnixListener); ok {
+    u.SetUnlinkOnClose(false)
+  }
+
+  s.listeners[name] = fl
+  return fl, nil
+}
+
+/**
+ * Duplicate the files underlying our listeners. The caller must hold the
+ * lock and is responsible for closing the returned files.
+ */
+func (s *Swapper) files() (map[string]*os.File, error) {
+  files := make(map[string]*os.File)
+  for k, l := range s.listeners {
+    f, err := l.File()
+    if err != nil {
+      for _, e := range files {
+        e.Close()
+      }
+      return nil, fmt.Errorf("Could not obtain listener %v: %v", k, err)
+    }
+    files[k] = f
+  }
+  return files, nil
+}
+
+/**
+ * Encode a set of named descriptors for the environment
+ */
+func encodeFiles(fds map[string]int) (string, error) {
+  data, err := json.Marshal(fds)
+  if err != nil {
+    return "", err
+  }
+  return string(data), nil
+}
+
+/**
+ * Decode a set of named descriptors from the environment
+ */
+func decodeFiles(v string) (map[string]*os.File, error) {
+  var fds map[string]int
+  err := json.Unmarshal([]byte(v), &fds)
+  if err != nil {
+    return nil, fmt.Errorf("Invalid inherited descriptors: %v", err)
+  }
+  files := make(map[string]*os.File)
+  for k, fd := range fds {
+    files[k] = os.NewFile(uintptr(fd), k)
+  }
+  return files, nil
+}
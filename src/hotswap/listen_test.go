@@ -0,0 +1,27 @@
+package hotswap
+
+import (
+  "testing"
+  "github.com/stretchr/testify/assert"
+)
+
+func TestEncodeFiles(t *testing.T) {
+  fds := map[string]int{"tcp:127.0.0.1:8080":3, "unix:/tmp/app.sock":4}
+
+  enc, err := encodeFiles(fds)
+  if !assert.Nil(t, err) { return }
+
+  files, err := decodeFiles(enc)
+  if !assert.Nil(t, err) { return }
+  if !assert.Len(t, files, 2) { return }
+  for k, fd := range fds {
+    f := files[k]
+    if assert.NotNil(t, f, k) {
+      assert.Equal(t, uintptr(fd), f.Fd())
+      assert.Equal(t, k, f.Name())
+    }
+  }
+
+  _, err = decodeFiles("{not json")
+  assert.NotNil(t, err)
+}
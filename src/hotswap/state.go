@@ -0,0 +1,168 @@
+package hotswap
+
+import (
+  "io"
+  "fmt"
+  "bufio"
+  "errors"
+  "encoding/binary"
+)
+
+// identifies a state stream
+var stateMagic = [4]byte{'H', 'S', 'S', 'T'}
+
+var (
+  ErrNoState = errors.New("No state was handed over by a previous generation")
+)
+
+/**
+ * Stream our state to a new generation. The envelope consists of a magic
+ * number and the state version, followed by the state itself in length-prefixed
+ * chunks terminated by an empty chunk. The terminator lets the reader tell a
+ * complete stream from one that was cut short.
+ */
+func (s *Swapper) writeState(w io.Writer) error {
+  cw := &chunkWriter{w:w}
+
+  err := binary.Write(cw.w, binary.BigEndian, struct{
+    Magic   [4]byte
+    Version uint32
+  }{stateMagic, uint32(s.conf.StateVersion)})
+  if err != nil {
+    return nil // the new generation hung up, it doesn't want our state
+  }
+
+  buf := bufio.NewWriterSize(cw, 32 << 10)
+  err = s.conf.SaveState(buf)
+  if err == nil {
+    err = buf.Flush()
+  }
+  if err == nil {
+    err = cw.Close()
+  }
+  if cw.err != nil {
+    return nil // as above
+  }
+
+  return err
+}
+
+/**
+ * Read the state handed over by the generation that spawned us. This returns
+ * the version of the state format it was written with and a reader for the
+ * state itself, which produces io.ErrUnexpectedEOF if the previous generation
+ * failed part way through. State must be read before calling Ready.
+ */
+func (s *Swapper) ReadState() (int, io.ReadCloser, error) {
+  s.Lock()
+  f := s.state
+  s.state = nil
+  s.Unlock()
+
+  if f == nil {
+    return 0, nil, ErrNoState
+  }
+
+  var hdr struct {
+    Magic   [4]byte
+    Version uint32
+  }
+  err := binary.Read(f, binary.BigEndian, &hdr)
+  if err != nil {
+    f.Close()
+    if err == io.EOF {
+      return 0, nil, ErrNoState
+    }
+    return 0, nil, fmt.Errorf("Could not read state: %v", err)
+  }
+  if hdr.Magic != stateMagic {
+    f.Close()
+    return 0, nil, fmt.Errorf("Invalid state stream")
+  }
+
+  return int(hdr.Version), &chunkReader{r:f, c:f}, nil
+}
+
+/**
+ * Writes length-prefixed chunks
+ */
+type chunkWriter struct {
+  w   io.Writer
+  err error
+}
+
+/**
+ * Write a chunk
+ */
+func (c *chunkWriter) Write(p []byte) (int, error) {
+  if len(p) < 1 {
+    return 0, nil
+  }
+  err := binary.Write(c.w, binary.BigEndian, uint32(len(p)))
+  if err == nil {
+    _, err = c.w.Write(p)
+  }
+  if err != nil {
+    c.err = err
+    return 0, err
+  }
+  return len(p), nil
+}
+
+/**
+ * Write the terminating chunk
+ */
+func (c *chunkWriter) Close() error {
+  err := binary.Write(c.w, binary.BigEndian, uint32(0))
+  if err != nil {
+    c.err = err
+  }
+  return err
+}
+
+/**
+ * Reads length-prefixed chunks
+ */
+type chunkReader struct {
+  r       io.Reader
+  c       io.Closer
+  remain  uint32
+  done    bool
+}
+
+/**
+ * Read chunk data
+ */
+func (c *chunkReader) Read(p []byte) (int, error) {
+  for c.remain == 0 {
+    if c.done {
+      return 0, io.EOF
+    }
+    err := binary.Read(c.r, binary.BigEndian, &c.remain)
+    if err == io.EOF {
+      return 0, io.ErrUnexpectedEOF
+    }else if err != nil {
+      return 0, err
+    }
+    if c.remain == 0 {
+      c.done = true
+    }
+  }
+
+  if uint32(len(p)) > c.remain {
+    p = p[:c.remain]
+  }
+  n, err := c.r.Read(p)
+  c.remain -= uint32(n)
+  if err == io.EOF {
+    err = io.ErrUnexpectedEOF
+  }
+  return n, err
+}
+
+/**
+ * Close the underlying stream
+ */
+func (c *chunkReader) Close() error {
+  return c.c.Close()
+}
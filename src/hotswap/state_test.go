@@ -0,0 +1,96 @@
+package hotswap
+
+import (
+  "io"
+  "os"
+  "bytes"
+  "errors"
+  "testing"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+// write a state stream the way a previous generation would
+func testWriteState(version int, save func(io.Writer) error) ([]byte, error) {
+  s := &Swapper{conf:Config{StateVersion:version, SaveState:save}}
+  var buf bytes.Buffer
+  err := s.writeState(&buf)
+  return buf.Bytes(), err
+}
+
+// hand a state stream to a new generation
+func testReadState(t *testing.T, data []byte) (int, io.ReadCloser, error) {
+  p := filepath.Join(t.TempDir(), "state")
+  err := os.WriteFile(p, data, 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  f, err := os.Open(p)
+  if err != nil {
+    t.Fatal(err)
+  }
+  s := &Swapper{state:f}
+  return s.ReadState()
+}
+
+func TestStateRoundTrip(t *testing.T) {
+  state := bytes.Repeat([]byte("some state "), 10000) // spans several chunks
+
+  data, err := testWriteState(3, func(w io.Writer) error {
+    _, err := w.Write(state)
+    return err
+  })
+  if !assert.Nil(t, err) { return }
+
+  v, r, err := testReadState(t, data)
+  if !assert.Nil(t, err) { return }
+  defer r.Close()
+  assert.Equal(t, 3, v)
+
+  actual, err := io.ReadAll(r)
+  if !assert.Nil(t, err) { return }
+  assert.Equal(t, state, actual)
+}
+
+func TestStateTruncated(t *testing.T) {
+  data, err := testWriteState(1, func(w io.Writer) error {
+    _, err := w.Write([]byte("all of the state"))
+    return err
+  })
+  if !assert.Nil(t, err) { return }
+
+  // without the terminator, and part way through a chunk
+  for _, n := range []int{len(data) - 4, len(data) - 8} {
+    _, r, err := testReadState(t, data[:n])
+    if !assert.Nil(t, err) { return }
+    _, err = io.ReadAll(r)
+    assert.Equal(t, io.ErrUnexpectedEOF, err, n)
+    r.Close()
+  }
+
+  // a previous generation which failed while saving
+  data, err = testWriteState(1, func(w io.Writer) error {
+    w.Write([]byte("some of the state"))
+    return errors.New("Failed")
+  })
+  assert.NotNil(t, err)
+  _, r, err := testReadState(t, data)
+  if !assert.Nil(t, err) { return }
+  _, err = io.ReadAll(r)
+  assert.Equal(t, io.ErrUnexpectedEOF, err)
+  r.Close()
+}
+
+func TestStateInvalid(t *testing.T) {
+  _, _, err := testReadState(t, []byte("NOPE\x00\x00\x00\x01\x00\x00\x00\x00"))
+  assert.NotNil(t, err)
+  assert.NotEqual(t, ErrNoState, err)
+
+  // nothing was written at all
+  _, _, err = testReadState(t, nil)
+  assert.Equal(t, ErrNoState, err)
+
+  // nothing was handed over
+  _, _, err = (&Swapper{}).ReadState()
+  assert.Equal(t, ErrNoState, err)
+}
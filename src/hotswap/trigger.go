@@ -0,0 +1,209 @@
+package hotswap
+
+import (
+  "os"
+  "os/signal"
+  "io"
+  "fmt"
+  "net"
+  "time"
+  "bufio"
+  "strings"
+  "syscall"
+  "path/filepath"
+)
+
+import (
+  "github.com/fsnotify/fsnotify"
+)
+
+/**
+ * Attempt an upgrade on behalf of a trigger and report the outcome
+ */
+func (s *Swapper) trigger(t string) error {
+  err := s.Upgrade()
+  if s.conf.OnUpgrade != nil {
+    s.conf.OnUpgrade(t, err)
+  }
+  return err
+}
+
+/**
+ * Upgrade when one of the specified signals is received. If no signals are
+ * provided, SIGHUP and SIGUSR2 are used. Signals which arrive while an
+ * upgrade is running are rejected with ErrUpgrading.
+ */
+func (s *Swapper) UpgradeOnSignal(sig ...os.Signal) {
+  if len(sig) < 1 {
+    sig = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
+  }
+
+  c := make(chan os.Signal, 1)
+  signal.Notify(c, sig...)
+
+  go func() {
+    for {
+      select {
+        case v := <- c:
+          go s.trigger(fmt.Sprintf("signal: %v", v))
+        case <- s.exit:
+          signal.Stop(c)
+          return
+      }
+    }
+  }()
+}
+
+/**
+ * Upgrade when the binary we were started from is replaced on disk. Events
+ * are grouped so that an upgrade is only attempted once the file has been
+ * quiet for the configured change delay.
+ */
+func (s *Swapper) UpgradeOnChange() error {
+  watcher, err := fsnotify.NewWatcher()
+  if err != nil {
+    return err
+  }
+
+  // watch the directory, since builds usually replace the file outright
+  err = watcher.Add(filepath.Dir(s.conf.Binary))
+  if err != nil {
+    watcher.Close()
+    return err
+  }
+
+  go func() {
+    defer watcher.Close()
+    var timer *time.Timer
+    var fire <-chan time.Time
+    for {
+      select {
+        case e, ok := <- watcher.Events:
+          if !ok {
+            return
+          }
+          if filepath.Clean(e.Name) != s.conf.Binary || e.Op & (fsnotify.Create | fsnotify.Write | fsnotify.Rename | fsnotify.Chmod) == 0 {
+            continue
+          }
+          if timer != nil {
+            timer.Stop()
+          }
+          timer = time.NewTimer(s.conf.ChangeDelay)
+          fire = timer.C
+        case err, ok := <- watcher.Errors:
+          if !ok {
+            return
+          }
+          if s.conf.OnUpgrade != nil {
+            s.conf.OnUpgrade("change", err)
+          }
+        case <- fire:
+          fire = nil
+          go s.trigger("change")
+        case <- s.exit:
+          return
+      }
+    }
+  }()
+
+  return nil
+}
+
+/**
+ * Accept commands on a unix control socket at the specified path. The socket
+ * is handed to the next generation like any other listener, so this must be
+ * called before Ready.
+ *
+ * The protocol is line-oriented. Each command produces a single line reply
+ * which is prefixed by "ok", "busy" or "error".
+ *
+ *   status   report the state of this generation
+ *   upgrade  upgrade and wait for the new generation to become ready
+ *
+ */
+func (s *Swapper) UpgradeOnCommand(path string) error {
+  s.Lock()
+  _, inherited := s.inherited["unix:"+ path]
+  s.Unlock()
+
+  if !inherited {
+    err := RemoveStaleSocket(path)
+    if err != nil {
+      return err
+    }
+  }
+
+  l, err := s.Listen("unix", path)
+  if err != nil {
+    return err
+  }
+
+  go func() {
+    <- s.exit
+    l.Close()
+  }()
+
+  go func() {
+    for {
+      conn, err := l.Accept()
+      if err != nil {
+        return
+      }
+      go s.command(conn)
+    }
+  }()
+
+  return nil
+}
+
+/**
+ * Handle a control connection
+ */
+func (s *Swapper) command(conn net.Conn) {
+  defer conn.Close()
+  r := bufio.NewScanner(conn)
+  for r.Scan() {
+    var rsp string
+    switch cmd := strings.TrimSpace(r.Text()); cmd {
+      case "":
+        continue
+      case "status":
+        rsp = fmt.Sprintf("ok %v pid=%d", s.Status(), os.Getpid())
+      case "upgrade":
+        switch err := s.trigger("command"); err {
+          case nil:
+            rsp = "ok upgraded"
+          case ErrUpgrading, ErrUpgraded:
+            rsp = fmt.Sprintf("busy %v", err)
+          default:
+            rsp = fmt.Sprintf("error %v", err)
+        }
+      default:
+        rsp = fmt.Sprintf("error Unknown command: %v", cmd)
+    }
+    _, err := io.WriteString(conn, rsp +"\n")
+    if err != nil {
+      return
+    }
+  }
+}
+
+/**
+ * Remove a socket file left behind by a process which is no longer listening.
+ * If something is still listening, the socket is left alone and an error is
+ * returned.
+ */
+func RemoveStaleSocket(path string) error {
+  _, err := os.Stat(path)
+  if os.IsNotExist(err) {
+    return nil
+  }else if err != nil {
+    return err
+  }
+  conn, err := net.Dial("unix", path)
+  if err == nil {
+    conn.Close()
+    return fmt.Errorf("Control socket is in use: %v", path)
+  }
+  return os.Remove(path)
+}
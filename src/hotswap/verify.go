@@ -0,0 +1,160 @@
+package hotswap
+
+import (
+  "os"
+  "fmt"
+  "time"
+  "bytes"
+  "strings"
+  "crypto/sha256"
+  "crypto/ed25519"
+  "crypto/x509"
+  "encoding/hex"
+  "encoding/pem"
+  "encoding/base64"
+)
+
+/**
+ * Verification performed on a binary before it's started. A binary which is
+ * still being written, or whose checksum or signature doesn't match yet, is
+ * retried until the timeout elapses since the build may simply not be done.
+ */
+type Verification struct {
+  Checksum  bool              // require a SHA-256 checksum in <binary>.sha256
+  PublicKey ed25519.PublicKey // if set, require an ed25519 signature in <binary>.sig
+  Settle    time.Duration     // how long the binary must be unchanged before it's considered written
+  Timeout   time.Duration     // how long to keep retrying before giving up
+}
+
+/**
+ * Verify a binary. The checksum file may be in the format produced by
+ * sha256sum. The signature file may contain either the raw signature or its
+ * base64 encoding and is made over the entire binary.
+ */
+func Verify(path string, v Verification) error {
+  if v.Settle <= 0 {
+    v.Settle = time.Millisecond * 250
+  }
+  if v.Timeout <= 0 {
+    v.Timeout = time.Second * 10
+  }
+
+  deadline := time.Now().Add(v.Timeout)
+  for {
+    err := settle(path, v.Settle, deadline)
+    if err == nil {
+      err = verify(path, v)
+    }
+    if err == nil || time.Now().After(deadline) {
+      return err
+    }
+    <- time.After(v.Settle)
+  }
+}
+
+/**
+ * Wait until a file stops changing
+ */
+func settle(path string, d time.Duration, deadline time.Time) error {
+  prev, err := os.Stat(path)
+  if err != nil {
+    return err
+  }
+  for {
+    <- time.After(d)
+    curr, err := os.Stat(path)
+    if err != nil {
+      return err
+    }
+    if curr.Size() == prev.Size() && curr.ModTime().Equal(prev.ModTime()) {
+      return nil
+    }
+    if time.Now().After(deadline) {
+      return fmt.Errorf("Binary is still changing: %v", path)
+    }
+    prev = curr
+  }
+}
+
+/**
+ * Check a binary once
+ */
+func verify(path string, v Verification) error {
+  if !v.Checksum && v.PublicKey == nil {
+    return nil
+  }
+
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return err
+  }
+
+  if v.Checksum {
+    sum, err := os.ReadFile(path +".sha256")
+    if err != nil {
+      return fmt.Errorf("Could not read checksum: %v", err)
+    }
+    f := strings.Fields(string(sum))
+    if len(f) < 1 {
+      return fmt.Errorf("Checksum file is empty: %v.sha256", path)
+    }
+    expect, err := hex.DecodeString(f[0])
+    if err != nil {
+      return fmt.Errorf("Invalid checksum: %v", err)
+    }
+    actual := sha256.Sum256(data)
+    if !bytes.Equal(expect, actual[:]) {
+      return fmt.Errorf("Checksum does not match: %v", path)
+    }
+  }
+
+  if v.PublicKey != nil {
+    sig, err := os.ReadFile(path +".sig")
+    if err != nil {
+      return fmt.Errorf("Could not read signature: %v", err)
+    }
+    if len(sig) != ed25519.SignatureSize {
+      sig, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+      if err != nil {
+        return fmt.Errorf("Invalid signature: %v", err)
+      }
+    }
+    if !ed25519.Verify(v.PublicKey, data, sig) {
+      return fmt.Errorf("Signature does not match: %v", path)
+    }
+  }
+
+  return nil
+}
+
+/**
+ * Load an ed25519 public key. The key may be PEM encoded, as produced by
+ * 'openssl pkey -pubout', or the base64 encoding of the raw key.
+ */
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+  data, err := os.ReadFile(path)
+  if err != nil {
+    return nil, err
+  }
+
+  if b, _ := pem.Decode(data); b != nil {
+    k, err := x509.ParsePKIXPublicKey(b.Bytes)
+    if err != nil {
+      return nil, err
+    }
+    e, ok := k.(ed25519.PublicKey)
+    if !ok {
+      return nil, fmt.Errorf("Not an ed25519 public key: %v", path)
+    }
+    return e, nil
+  }
+
+  k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+  if err != nil {
+    return nil, fmt.Errorf("Invalid public key: %v", err)
+  }
+  if len(k) != ed25519.PublicKeySize {
+    return nil, fmt.Errorf("Invalid public key length: %v", path)
+  }
+  return ed25519.PublicKey(k), nil
+}
@@ -0,0 +1,83 @@
+package hotswap
+
+import (
+  "os"
+  "time"
+  "testing"
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/ed25519"
+  "encoding/hex"
+  "encoding/base64"
+  "path/filepath"
+  "github.com/stretchr/testify/assert"
+)
+
+// settle quickly and give up soon, so failures don't take long
+func testVerification() Verification {
+  return Verification{Settle:time.Millisecond * 10, Timeout:time.Millisecond * 50}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+  bin := filepath.Join(t.TempDir(), "app")
+  data := []byte("a binary, more or less")
+  err := os.WriteFile(bin, data, 0755)
+  if !assert.Nil(t, err) { return }
+
+  v := testVerification()
+  v.Checksum = true
+
+  // no checksum at all
+  assert.NotNil(t, Verify(bin, v))
+
+  // the format produced by sha256sum
+  sum := sha256.Sum256(data)
+  err = os.WriteFile(bin +".sha256", []byte(hex.EncodeToString(sum[:]) +"  app\n"), 0644)
+  if !assert.Nil(t, err) { return }
+  assert.Nil(t, Verify(bin, v))
+
+  err = os.WriteFile(bin +".sha256", []byte(hex.EncodeToString(make([]byte, sha256.Size)) +"\n"), 0644)
+  if !assert.Nil(t, err) { return }
+  assert.NotNil(t, Verify(bin, v))
+}
+
+func TestVerifySignature(t *testing.T) {
+  dir := t.TempDir()
+  bin := filepath.Join(dir, "app")
+  data := []byte("a binary, more or less")
+  err := os.WriteFile(bin, data, 0755)
+  if !assert.Nil(t, err) { return }
+
+  pub, key, err := ed25519.GenerateKey(rand.Reader)
+  if !assert.Nil(t, err) { return }
+  other, _, err := ed25519.GenerateKey(rand.Reader)
+  if !assert.Nil(t, err) { return }
+
+  v := testVerification()
+  v.PublicKey = pub
+
+  // no signature at all
+  assert.NotNil(t, Verify(bin, v))
+
+  // raw and base64 encoded signatures
+  sig := ed25519.Sign(key, data)
+  err = os.WriteFile(bin +".sig", sig, 0644)
+  if !assert.Nil(t, err) { return }
+  assert.Nil(t, Verify(bin, v))
+
+  err = os.WriteFile(bin +".sig", []byte(base64.StdEncoding.EncodeToString(sig) +"\n"), 0644)
+  if !assert.Nil(t, err) { return }
+  assert.Nil(t, Verify(bin, v))
+
+  // signed, but not by the key we trust
+  v.PublicKey = other
+  assert.NotNil(t, Verify(bin, v))
+
+  // the key may be loaded from a file
+  err = os.WriteFile(filepath.Join(dir, "key.pub"), []byte(base64.StdEncoding.EncodeToString(pub)), 0644)
+  if !assert.Nil(t, err) { return }
+  k, err := LoadPublicKey(filepath.Join(dir, "key.pub"))
+  if assert.Nil(t, err) {
+    assert.Equal(t, pub, k)
+  }
+}